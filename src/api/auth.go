@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// environment variable the key tokens are signed with is read from
+const secretVariable = "PLACES_TOKEN_SECRET"
+
+// shorter keys make HS256 tokens easy to brute force
+const minSecretLength = 32
+
+// user names end up in review ids, so they are restricted to a safe alphabet
+var userPattern = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,64}$`)
+
+// cheaper hashes of a leaked users file are too fast to brute force
+const minPasswordCost = bcrypt.DefaultCost
+
+// password of a user is stored as bcrypt hash, which carries its own salt and cost
+type userCredentials struct {
+	hash []byte
+}
+
+func (credentials userCredentials) matches(password string) bool {
+	return bcrypt.CompareHashAndPassword(credentials.hash, []byte(password)) == nil
+}
+
+type tokenIssuer struct {
+	secret []byte
+	ttl    time.Duration
+	users  map[string]userCredentials
+
+	// password of unknown users is checked against it, so they take as long to refuse as wrong passwords
+	unknownUser userCredentials
+}
+
+func newTokenIssuer(secret string, ttl time.Duration, usersPath string) (*tokenIssuer, error) {
+	if len(secret) < minSecretLength {
+		return nil, fmt.Errorf("%s must be set to a secret of at least %d bytes", secretVariable, minSecretLength)
+	}
+	if ttl <= 0 {
+		return nil, fmt.Errorf("token lifetime must be positive, got %s", ttl)
+	}
+
+	unknownHash, err := bcrypt.GenerateFromPassword([]byte(secret), minPasswordCost)
+	if err != nil {
+		return nil, err
+	}
+
+	issuer := &tokenIssuer{
+		secret:      []byte(secret),
+		ttl:         ttl,
+		users:       make(map[string]userCredentials),
+		unknownUser: userCredentials{hash: unknownHash},
+	}
+	if usersPath == "" {
+		return issuer, nil
+	}
+
+	if issuer.users, err = loadUsers(usersPath); err != nil {
+		return nil, err
+	}
+	return issuer, nil
+}
+
+// Reads users allowed to get a personal token, one "name:bcrypt hash" per line,
+// which is the format of htpasswd, e.g.
+//
+//	htpasswd -nBC 12 alice >> users
+//
+// Hashes of cost below minPasswordCost are refused.
+// Empty lines and lines starting with "#" are skipped.
+func loadUsers(path string) (map[string]userCredentials, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	users := make(map[string]userCredentials)
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		fields := strings.Split(text, ":")
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: expected \"name:bcrypt hash\"", path, line)
+		}
+		if !userPattern.MatchString(fields[0]) {
+			return nil, fmt.Errorf("%s:%d: invalid user name %q", path, line, fields[0])
+		}
+		hash := []byte(fields[1])
+		cost, err := bcrypt.Cost(hash)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid bcrypt hash of user %q: %s", path, line, fields[0], err)
+		}
+		if cost < minPasswordCost {
+			return nil, fmt.Errorf("%s:%d: bcrypt cost of user %q is %d, at least %d is required", path, line, fields[0], cost, minPasswordCost)
+		}
+
+		users[fields[0]] = userCredentials{hash: hash}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return users, nil
+}
+
+// user becomes the subject of the token, tokens without one can't be used to write reviews
+func (issuer *tokenIssuer) createToken(user string, now time.Time) (string, error) {
+	claims := jwt.MapClaims{
+		"iat": now.Unix(),
+		"exp": now.Add(issuer.ttl).Unix(),
+	}
+	if user != "" {
+		claims["sub"] = user
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+
+	stringToken, err := token.SignedString(issuer.secret)
+	if err != nil {
+		return "", err
+	}
+
+	return stringToken, nil
+}
+
+// returns the subject of a valid token, which is empty for tokens issued without a user
+func (issuer *tokenIssuer) tokenUser(token string) (string, error) {
+	t, err := jwt.Parse(
+		token,
+		func(token *jwt.Token) (any, error) {
+			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+				return nil, fmt.Errorf("error while formatting")
+			}
+
+			return issuer.secret, nil
+		},
+	)
+
+	if err != nil {
+		return "", err
+	}
+
+	if !t.Valid {
+		return "", fmt.Errorf("unathorized")
+	}
+
+	// tokens issued before expiration was introduced never expire, so they are refused
+	claims, _ := t.Claims.(jwt.MapClaims)
+	if !claims.VerifyExpiresAt(time.Now().Unix(), true) {
+		return "", fmt.Errorf("token has no expiration time")
+	}
+
+	user, _ := claims["sub"].(string)
+	return user, nil
+}
+
+// returns the subject of the token, writing the error response if there is none
+func (issuer *tokenIssuer) authenticatedUser(w http.ResponseWriter, r *http.Request, encoder *json.Encoder) (string, bool) {
+	var token string
+	fmt.Sscanf(r.Header.Get("Authorization"), "Bearer %s", &token)
+
+	user, err := issuer.tokenUser(token)
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		encoder.Encode(invalidPageJson{"unathorized"})
+		log.Println(err)
+		return "", false
+	}
+	if user == "" {
+		w.WriteHeader(http.StatusForbidden)
+		encoder.Encode(invalidPageJson{"token has no user, get one from /api/get_token with basic authentication"})
+		return "", false
+	}
+
+	return user, true
+}
+
+// Issues an anonymous token, or a personal one to a user passing basic authentication.
+// Unknown users and wrong passwords are refused the same way, so neither can be guessed separately.
+func (issuer *tokenIssuer) getToken(w http.ResponseWriter, r *http.Request) {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+
+	w.Header().Add("Content-Type", "application/json")
+
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		encoder.Encode(invalidPageJson{"not a GET method"})
+		log.Println("not a get method")
+		return
+	}
+
+	type response struct {
+		Token     string    `json:"token"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}
+
+	if r.URL.Query().Has("user") {
+		w.WriteHeader(http.StatusBadRequest)
+		encoder.Encode(invalidPageJson{`"user" is not accepted anymore, pass the name and password with basic authentication`})
+		return
+	}
+
+	user, password, hasCredentials := r.BasicAuth()
+	if hasCredentials {
+		credentials, exists := issuer.users[user]
+		if !exists {
+			// hashing anyway keeps the response time the same as for a wrong password
+			credentials = issuer.unknownUser
+		}
+
+		if !credentials.matches(password) || !exists {
+			w.Header().Set("WWW-Authenticate", `Basic realm="places"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			encoder.Encode(invalidPageJson{"invalid user or password"})
+			log.Printf("failed authentication of %q\n", user)
+			return
+		}
+	}
+
+	now := time.Now()
+	token, err := issuer.createToken(user, now)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		encoder.Encode(invalidPageJson{"error while creating token"})
+		log.Println(err)
+		return
+	}
+
+	encoder.Encode(response{Token: token, ExpiresAt: now.Add(issuer.ttl).UTC().Truncate(time.Second)})
+}
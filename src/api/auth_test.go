@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const testSecret = "0123456789abcdef0123456789abcdef"
+
+func newTestIssuer(t *testing.T) *tokenIssuer {
+	t.Helper()
+
+	issuer, err := newTokenIssuer(testSecret, time.Hour, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte("correct horse"), minPasswordCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuer.users["alice"] = userCredentials{hash: hash}
+
+	return issuer
+}
+
+func TestNewTokenIssuerRejectsShortSecret(t *testing.T) {
+	if _, err := newTokenIssuer("SomeSuperSecretKey", time.Hour, ""); err == nil {
+		t.Error("short secret is accepted")
+	}
+}
+
+func TestLoadUsers(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("correct horse"), minPasswordCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cheapHash, err := bcrypt.GenerateFromPassword([]byte("correct horse"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		content string
+		wantErr bool
+	}{
+		{name: "hash and comments", content: "# users\n\nalice:" + string(hash) + "\n"},
+		{name: "htpasswd prefix", content: "alice:" + strings.Replace(string(hash), "$2a$", "$2y$", 1)},
+		{name: "old salted sha256", content: "alice:pepper:" + strings.Repeat("ab", 32), wantErr: true},
+		{name: "not a hash", content: "alice:correct horse", wantErr: true},
+		{name: "cheap hash", content: "alice:" + string(cheapHash), wantErr: true},
+		{name: "invalid name", content: "al ice:" + string(hash), wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "users")
+			if err := os.WriteFile(path, []byte(test.content), 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			users, err := loadUsers(path)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("error = %v, want error %v", err, test.wantErr)
+			}
+			if !test.wantErr && !users["alice"].matches("correct horse") {
+				t.Error("password of alice doesn't match")
+			}
+		})
+	}
+}
+
+func TestGetToken(t *testing.T) {
+	issuer := newTestIssuer(t)
+
+	tests := []struct {
+		name     string
+		target   string
+		user     string
+		password string
+		status   int
+		subject  string
+	}{
+		{name: "anonymous", target: "/api/get_token", status: http.StatusOK},
+		{name: "valid credentials", target: "/api/get_token", user: "alice", password: "correct horse", status: http.StatusOK, subject: "alice"},
+		{name: "wrong password", target: "/api/get_token", user: "alice", password: "battery staple", status: http.StatusUnauthorized},
+		{name: "unknown user", target: "/api/get_token", user: "mallory", password: "correct horse", status: http.StatusUnauthorized},
+		{name: "user without credentials", target: "/api/get_token?user=alice", status: http.StatusBadRequest},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, test.target, nil)
+			if test.user != "" {
+				request.SetBasicAuth(test.user, test.password)
+			}
+			recorder := httptest.NewRecorder()
+
+			issuer.getToken(recorder, request)
+
+			if recorder.Code != test.status {
+				t.Fatalf("status = %d, want %d: %s", recorder.Code, test.status, recorder.Body)
+			}
+			if test.status != http.StatusOK {
+				return
+			}
+
+			var response struct {
+				Token string `json:"token"`
+			}
+			if err := json.NewDecoder(recorder.Body).Decode(&response); err != nil {
+				t.Fatal(err)
+			}
+			subject, err := issuer.tokenUser(response.Token)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if subject != test.subject {
+				t.Errorf("subject = %q, want %q", subject, test.subject)
+			}
+		})
+	}
+}
+
+func TestTokenUser(t *testing.T) {
+	issuer := newTestIssuer(t)
+
+	sign := func(secret string, claims jwt.MapClaims) string {
+		token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(secret))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	valid, err := issuer.createToken("alice", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired, err := issuer.createToken("alice", time.Now().Add(-2*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr bool
+	}{
+		{name: "valid", token: valid},
+		{name: "expired", token: expired, wantErr: true},
+		{name: "without expiration", token: sign(testSecret, jwt.MapClaims{"sub": "alice"}), wantErr: true},
+		{name: "signed with another secret", token: sign(strings.Repeat("x", 32), jwt.MapClaims{"sub": "alice", "exp": time.Now().Add(time.Hour).Unix()}), wantErr: true},
+		{name: "empty", token: "", wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			user, err := issuer.tokenUser(test.token)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("error = %v, want error %v", err, test.wantErr)
+			}
+			if !test.wantErr && user != "alice" {
+				t.Errorf("user = %q, want \"alice\"", user)
+			}
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"common"
+	"fmt"
+	"net/url"
+	"paginate"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const maxSearchLength = 200
+
+// values of a parameter given either several times or separated by commas
+func listParameter(query url.Values, name string) []string {
+	var values []string
+	for _, parameter := range query[name] {
+		for _, value := range strings.Split(parameter, ",") {
+			if value = strings.TrimSpace(value); value != "" {
+				values = append(values, value)
+			}
+		}
+	}
+	return values
+}
+
+// parses "q" search, "cuisine", "price_level" and "district" filters along with requested "facets"
+func parsePlaceFilter(query url.Values) (paginate.Filter, []string, error) {
+	filter := paginate.Filter{
+		Search:   strings.TrimSpace(query.Get("q")),
+		District: listParameter(query, "district"),
+	}
+	if utf8.RuneCountInString(filter.Search) > maxSearchLength {
+		return paginate.Filter{}, nil, fmt.Errorf(`"q" is longer than %d characters`, maxSearchLength)
+	}
+
+	// cuisines are stored lowercased
+	for _, cuisine := range listParameter(query, "cuisine") {
+		filter.Cuisine = append(filter.Cuisine, strings.ToLower(cuisine))
+	}
+
+	for _, value := range listParameter(query, "price_level") {
+		level, err := strconv.Atoi(value)
+		if err != nil || level < 1 || level > common.MaxPriceLevel {
+			return paginate.Filter{}, nil, fmt.Errorf(`invalid "price_level" value: %v, expected from 1 to %d`, value, common.MaxPriceLevel)
+		}
+		filter.PriceLevel = append(filter.PriceLevel, level)
+	}
+
+	facets := listParameter(query, "facets")
+	for _, facet := range facets {
+		if !paginate.IsFacetField(facet) {
+			return paginate.Filter{}, nil, fmt.Errorf(`invalid "facets" value: %v, expected some of %q`, facet, paginate.FacetFields)
+		}
+	}
+
+	return filter, facets, nil
+}
@@ -2,4 +2,7 @@ module api
 
 go 1.21.1
 
-require github.com/golang-jwt/jwt v3.2.2+incompatible
+require (
+	github.com/golang-jwt/jwt v3.2.2+incompatible
+	golang.org/x/crypto v0.17.0
+)
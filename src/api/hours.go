@@ -0,0 +1,67 @@
+package main
+
+import (
+	"common"
+	"fmt"
+	"log"
+	"net/url"
+	"strconv"
+	"time"
+	_ "time/tzdata" // the server may run without system timezone database
+)
+
+var placesLocation = loadPlacesLocation()
+
+func loadPlacesLocation() *time.Location {
+	location, err := time.LoadLocation(common.PlacesTimezone)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	return location
+}
+
+// Parses "open_now=true" or "open_at=2026-10-17T21:00" into the moment places
+// must be open at, in the timezone of places. Returns nil if neither is set.
+func parseOpenFilter(query url.Values) (*time.Time, error) {
+	if value := query.Get("open_at"); value != "" {
+		moment, err := time.ParseInLocation("2006-01-02T15:04", value, placesLocation)
+		if err != nil {
+			if moment, err = time.Parse(time.RFC3339, value); err != nil {
+				return nil, fmt.Errorf(`invalid "open_at" value: %v, expected e.g. 2026-10-17T21:00`, value)
+			}
+		}
+
+		moment = moment.In(placesLocation)
+		return &moment, nil
+	}
+
+	if value := query.Get("open_now"); value != "" {
+		openNow, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf(`invalid "open_now" value: %v`, value)
+		}
+		if !openNow {
+			return nil, nil
+		}
+
+		moment := time.Now().In(placesLocation)
+		return &moment, nil
+	}
+
+	return nil, nil
+}
+
+// places without opening hours are left out, since it's unknown whether they are open
+func isOpen(place common.Place, moment time.Time) bool {
+	return place.OpeningHours != nil && place.OpeningHours.IsOpenAt(moment)
+}
+
+func filterOpen(places []common.Place, moment time.Time) []common.Place {
+	open := make([]common.Place, 0, len(places))
+	for _, place := range places {
+		if isOpen(place, moment) {
+			open = append(open, place)
+		}
+	}
+	return open
+}
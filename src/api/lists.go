@@ -0,0 +1,350 @@
+package main
+
+import (
+	"common"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const maxListNameLength = 100
+
+type placeListRequest struct {
+	Name     string   `json:"name"`
+	PlaceIDs []uint64 `json:"place_ids"`
+}
+
+type placeListResponse struct {
+	ID        string         `json:"id"`
+	Name      string         `json:"name"`
+	Places    []common.Place `json:"places"`
+	CreatedAt *time.Time     `json:"created_at,omitempty"`
+	UpdatedAt *time.Time     `json:"updated_at,omitempty"`
+}
+
+type placeListsResponse struct {
+	Name  string              `json:"name"`
+	Lists []placeListResponse `json:"lists"`
+}
+
+// random hex id, never equal to the id of favorites
+func newListID() (string, error) {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id), nil
+}
+
+// Routes favorites and lists of the user the token was issued to:
+//
+//	GET          /api/me/favorites
+//	PUT, DELETE  /api/me/favorites/{place id}
+//	GET, POST    /api/me/lists
+//	GET, PATCH, DELETE  /api/me/lists/{list id}
+//	PUT, DELETE  /api/me/lists/{list id}/places/{place id}
+func (paginator *Paginator) meApi(w http.ResponseWriter, r *http.Request) {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+
+	w.Header().Add("Content-Type", "application/json")
+
+	user, ok := paginator.Tokens.authenticatedUser(w, r, encoder)
+	if !ok {
+		return
+	}
+
+	segments := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/me/"), "/"), "/")
+	switch {
+	case len(segments) == 1 && segments[0] == "favorites":
+		paginator.handleList(w, r, encoder, user, common.FavoritesListID)
+	case len(segments) == 2 && segments[0] == "favorites":
+		paginator.handleListPlace(w, r, encoder, user, common.FavoritesListID, segments[1])
+	case len(segments) == 1 && segments[0] == "lists":
+		paginator.handleLists(w, r, encoder, user)
+	case len(segments) == 2 && segments[0] == "lists" && segments[1] != common.FavoritesListID:
+		paginator.handleList(w, r, encoder, user, segments[1])
+	case len(segments) == 4 && segments[0] == "lists" && segments[1] != common.FavoritesListID && segments[2] == "places":
+		paginator.handleListPlace(w, r, encoder, user, segments[1], segments[3])
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		encoder.Encode(invalidPageJson{"not found"})
+	}
+}
+
+// embeds places into the list, places which no longer exist are left out
+func (paginator *Paginator) buildListResponse(list common.PlaceList) (placeListResponse, error) {
+	places, err := paginator.ElasticPaginator.GetPlacesByIDs(list.PlaceIDs)
+	if err != nil {
+		return placeListResponse{}, err
+	}
+
+	return placeListResponse{
+		ID:        list.ID,
+		Name:      list.Name,
+		Places:    places,
+		CreatedAt: &list.CreatedAt,
+		UpdatedAt: &list.UpdatedAt,
+	}, nil
+}
+
+func (paginator *Paginator) handleLists(w http.ResponseWriter, r *http.Request, encoder *json.Encoder, user string) {
+	switch r.Method {
+	case http.MethodGet:
+		lists, err := paginator.Lists.GetLists(user)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			encoder.Encode(invalidPageJson{err.Error()})
+			log.Println(err)
+			return
+		}
+
+		response := placeListsResponse{Name: "Lists", Lists: make([]placeListResponse, 0, len(lists))}
+		for _, list := range lists {
+			listResponse, err := paginator.buildListResponse(list)
+			if err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				encoder.Encode(invalidPageJson{err.Error()})
+				log.Println(err)
+				return
+			}
+			response.Lists = append(response.Lists, listResponse)
+		}
+
+		encoder.Encode(response)
+
+	case http.MethodPost:
+		request, ok := decodeListRequest(w, r, encoder)
+		if !ok {
+			return
+		}
+		if request.PlaceIDs == nil {
+			request.PlaceIDs = make([]uint64, 0)
+		}
+
+		places, err := paginator.ElasticPaginator.GetPlacesByIDs(request.PlaceIDs)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			encoder.Encode(invalidPageJson{err.Error()})
+			log.Println(err)
+			return
+		}
+		if len(places) != len(request.PlaceIDs) {
+			w.WriteHeader(http.StatusBadRequest)
+			encoder.Encode(invalidPageJson{`some of "place_ids" don't exist`})
+			return
+		}
+
+		id, err := newListID()
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			encoder.Encode(invalidPageJson{err.Error()})
+			log.Println(err)
+			return
+		}
+
+		now := time.Now().UTC()
+		list := common.PlaceList{
+			ID:        id,
+			User:      user,
+			Name:      request.Name,
+			PlaceIDs:  request.PlaceIDs,
+			CreatedAt: now,
+			UpdatedAt: now,
+		}
+		if err := paginator.Lists.CreateList(list); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			encoder.Encode(invalidPageJson{err.Error()})
+			log.Println(err)
+			return
+		}
+
+		w.WriteHeader(http.StatusCreated)
+		encoder.Encode(placeListResponse{
+			ID:        list.ID,
+			Name:      list.Name,
+			Places:    places,
+			CreatedAt: &list.CreatedAt,
+			UpdatedAt: &list.UpdatedAt,
+		})
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		encoder.Encode(invalidPageJson{"not a GET or POST method"})
+		log.Println("not a get or post method")
+	}
+}
+
+func (paginator *Paginator) handleList(w http.ResponseWriter, r *http.Request, encoder *json.Encoder, user, id string) {
+	isFavorites := id == common.FavoritesListID
+
+	switch {
+	case r.Method == http.MethodGet:
+		list, err := paginator.Lists.GetList(user, id)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			encoder.Encode(invalidPageJson{err.Error()})
+			log.Println(err)
+			return
+		}
+
+		// favorites exist even before anything is added to them
+		if list == nil && isFavorites {
+			encoder.Encode(placeListResponse{ID: id, Name: "Favorites", Places: make([]common.Place, 0)})
+			return
+		}
+		if list == nil {
+			w.WriteHeader(http.StatusNotFound)
+			encoder.Encode(invalidPageJson{fmt.Sprintf("there is no list with id %s", id)})
+			return
+		}
+
+		response, err := paginator.buildListResponse(*list)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			encoder.Encode(invalidPageJson{err.Error()})
+			log.Println(err)
+			return
+		}
+		encoder.Encode(response)
+
+	case r.Method == http.MethodPatch && !isFavorites:
+		request, ok := decodeListRequest(w, r, encoder)
+		if !ok {
+			return
+		}
+		if request.PlaceIDs != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			encoder.Encode(invalidPageJson{`only "name" can be changed, places are added and removed one by one`})
+			return
+		}
+
+		found, err := paginator.Lists.RenameList(user, id, request.Name)
+		paginator.writeListChange(w, encoder, user, id, found, err)
+
+	case r.Method == http.MethodDelete && !isFavorites:
+		found, err := paginator.Lists.DeleteList(user, id)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			encoder.Encode(invalidPageJson{err.Error()})
+			log.Println(err)
+			return
+		}
+		if !found {
+			w.WriteHeader(http.StatusNotFound)
+			encoder.Encode(invalidPageJson{fmt.Sprintf("there is no list with id %s", id)})
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		encoder.Encode(invalidPageJson{fmt.Sprintf("method %s is not allowed", r.Method)})
+		log.Printf("method %s is not allowed\n", r.Method)
+	}
+}
+
+func (paginator *Paginator) handleListPlace(w http.ResponseWriter, r *http.Request, encoder *json.Encoder, user, id, placeValue string) {
+	placeID, err := strconv.ParseUint(placeValue, 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		encoder.Encode(invalidPageJson{"not found"})
+		return
+	}
+
+	var found bool
+	switch r.Method {
+	case http.MethodPut:
+		place, err := paginator.ElasticPaginator.GetPlace(placeID)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			encoder.Encode(invalidPageJson{err.Error()})
+			log.Println(err)
+			return
+		}
+		if place == nil {
+			w.WriteHeader(http.StatusNotFound)
+			encoder.Encode(invalidPageJson{fmt.Sprintf("there is no place with id %d", placeID)})
+			return
+		}
+
+		found, err = paginator.Lists.AddPlace(user, id, placeID)
+	case http.MethodDelete:
+		found, err = paginator.Lists.RemovePlace(user, id, placeID)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		encoder.Encode(invalidPageJson{"not a PUT or DELETE method"})
+		log.Println("not a put or delete method")
+		return
+	}
+
+	paginator.writeListChange(w, encoder, user, id, found, err)
+}
+
+// responds with the changed list
+func (paginator *Paginator) writeListChange(w http.ResponseWriter, encoder *json.Encoder, user, id string, found bool, err error) {
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		encoder.Encode(invalidPageJson{err.Error()})
+		log.Println(err)
+		return
+	}
+
+	// removing a place from favorites which were never created changes nothing
+	if !found && id == common.FavoritesListID {
+		encoder.Encode(placeListResponse{ID: id, Name: "Favorites", Places: make([]common.Place, 0)})
+		return
+	}
+	if !found {
+		w.WriteHeader(http.StatusNotFound)
+		encoder.Encode(invalidPageJson{fmt.Sprintf("there is no list with id %s", id)})
+		return
+	}
+
+	list, err := paginator.Lists.GetList(user, id)
+	if err == nil && list == nil {
+		err = fmt.Errorf("list %s has disappeared", id)
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		encoder.Encode(invalidPageJson{err.Error()})
+		log.Println(err)
+		return
+	}
+
+	response, err := paginator.buildListResponse(*list)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		encoder.Encode(invalidPageJson{err.Error()})
+		log.Println(err)
+		return
+	}
+	encoder.Encode(response)
+}
+
+func decodeListRequest(w http.ResponseWriter, r *http.Request, encoder *json.Encoder) (placeListRequest, bool) {
+	var request placeListRequest
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&request); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		encoder.Encode(invalidPageJson{fmt.Sprintf("invalid list: %s", err)})
+		return placeListRequest{}, false
+	}
+
+	request.Name = strings.TrimSpace(request.Name)
+	if request.Name == "" || utf8.RuneCountInString(request.Name) > maxListNameLength {
+		w.WriteHeader(http.StatusBadRequest)
+		encoder.Encode(invalidPageJson{fmt.Sprintf(`"name" must be from 1 to %d characters long`, maxListNameLength)})
+		return placeListRequest{}, false
+	}
+
+	return request, true
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"common"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Takes the location of a request from the first of "lat" and "lon", "ll=lat,lon"
+// or "geohash" parameters, falling back to the X-Geo header with "lat,lon"
+// or a "geo:lat,lon;u=10" URI. Passing several parameters is ambiguous.
+func parseLocation(r *http.Request) (common.Location, error) {
+	query := r.URL.Query()
+
+	var forms []string
+	for _, parameter := range []string{"lat", "lon", "ll", "geohash"} {
+		if query.Has(parameter) {
+			forms = append(forms, parameter)
+		}
+	}
+
+	switch {
+	case len(forms) == 0:
+		header := r.Header.Get("X-Geo")
+		if header == "" {
+			return common.Location{}, fmt.Errorf(`location is required, pass "lat" and "lon", "ll" or "geohash" parameters or X-Geo header`)
+		}
+
+		value, _, _ := strings.Cut(strings.TrimPrefix(strings.TrimSpace(header), "geo:"), ";")
+		return common.ParseLatLon("X-Geo", value)
+	case len(forms) == 2 && forms[0] == "lat" && forms[1] == "lon":
+		var location common.Location
+		var err error
+		if location.Latitude, err = common.ParseLatitude("lat", query.Get("lat")); err != nil {
+			return common.Location{}, err
+		}
+		if location.Longitude, err = common.ParseLongitude("lon", query.Get("lon")); err != nil {
+			return common.Location{}, err
+		}
+		return location, nil
+	case len(forms) == 1 && forms[0] == "ll":
+		return common.ParseLatLon("ll", query.Get("ll"))
+	case len(forms) == 1 && forms[0] == "geohash":
+		return common.ParseGeohash("geohash", query.Get("geohash"))
+	case len(forms) == 1 && (forms[0] == "lat" || forms[0] == "lon"):
+		return common.Location{}, fmt.Errorf(`"lat" and "lon" must be passed together`)
+	default:
+		return common.Location{}, fmt.Errorf(`pass only one of "lat" and "lon", "ll" or "geohash", got %q`, forms)
+	}
+}
@@ -11,160 +11,82 @@ import (
 	"net/http"
 	"os"
 	"paginate"
+	"regexp"
 	"strconv"
 	"strings"
-
-	"github.com/golang-jwt/jwt"
+	"time"
 )
 
-const body = `
-<!doctype html>
-<html>
-<head>
-    <meta charset="utf-8">
-    <title>Places</title>
-    <meta name="description" content="">
-    <meta name="viewport" content="width=device-width, initial-scale=1">
-</head>
-
-<body>
-<h5>Total: %d</h5>
-<h5>Current: %d</h5>
-<ul>
-%s
-</ul>
-%s
-</body>
-</html>`
-
-func createButton(page int, name string) string {
-	return fmt.Sprintf(`<a href="/?page=%d">%s</a>`, page, name)
-}
+const pageSize = 10
 
-func createPlaceEntry(place common.Place) string {
-	return fmt.Sprintf(
-		`
-		<li>
-			<div>%s</div>
-			<div>%s</div>
-			<div>%s</div>
-		</li>`,
-		place.Name,
-		place.Address,
-		place.Phone,
-	)
+type Paginator struct {
+	ElasticPaginator paginate.ElasticPaginator
+	Reviews          paginate.ElasticReviews
+	Lists            paginate.ListStore
+	Tokens           *tokenIssuer
 }
 
-func buildPage(total, pageSize, page int, places []common.Place) string {
-	var stringPages []string
-	if len(places) < pageSize {
-		stringPages = make([]string, len(places))
-	} else {
-		stringPages = make([]string, pageSize)
-	}
-
-	for i, place := range places {
-		stringPages[i] = createPlaceEntry(place)
-	}
-
-	entries := strings.Join(stringPages, "\n")
-
-	var firstButton, prevButton, nextButton, lastButton string
-	if page != 1 && total != 1 {
-		firstButton = createButton(1, "First")
-		prevButton = createButton(page-1, "Previous")
-	}
-	if page != total {
-		nextButton = createButton(page+1, "Next")
-		lastButton = createButton(total, "Last")
-	}
-
-	stringButtons := strings.Join(
-		[]string{
-			firstButton,
-			prevButton,
-			nextButton,
-			lastButton,
-		},
-		"\n",
-	)
-
-	return fmt.Sprintf(body, total, page, entries, stringButtons)
+type invalidPageJson struct {
+	Error string `json:"error"`
 }
 
-const pageSize = 10
-
-type Paginator struct {
-	ElasticPaginator paginate.ElasticPaginator
+type jsonResponse struct {
+	Name      string                            `json:"name"`
+	Total     int                               `json:"total"`
+	Places    []common.Place                    `json:"places"`
+	Facets    map[string][]paginate.FacetBucket `json:"facets,omitempty"`
+	FirstPage *int                              `json:"first_page,omitempty"`
+	PrevPage  *int                              `json:"prev_page,omitempty"`
+	NextPage  *int                              `json:"next_page,omitempty"`
+	LastPage  *int                              `json:"last_page,omitempty"`
 }
 
-func (paginator *Paginator) showPage(w http.ResponseWriter, r *http.Request) {
+func (paginator *Paginator) returnJSON(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		http.Error(w, "not a get method", http.StatusMethodNotAllowed)
 		log.Println("not a get method")
 		return
 	}
 
-	places, totalDocumentsCount, err := paginator.ElasticPaginator.GetPlaces(math.MaxInt32, 0)
+	filter, facetFields, err := parsePlaceFilter(r.URL.Query())
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		marshalized, _ := json.MarshalIndent(invalidPageJson{err.Error()}, "", "  ")
+		w.Header().Add("Content-Type", "application/json")
+		http.Error(w, string(marshalized), http.StatusBadRequest)
 		log.Println(err)
 		return
 	}
 
-	requestedPage, err := strconv.ParseInt(r.URL.Query().Get("page"), 10, 32)
+	openAt, err := parseOpenFilter(r.URL.Query())
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		marshalized, _ := json.MarshalIndent(invalidPageJson{err.Error()}, "", "  ")
+		w.Header().Add("Content-Type", "application/json")
+		http.Error(w, string(marshalized), http.StatusBadRequest)
 		log.Println(err)
 		return
 	}
 
-	totalPagesCount := totalDocumentsCount / pageSize
-	if totalDocumentsCount%10 != 0 {
-		totalPagesCount++
-	}
-
-	if requestedPage <= 0 || requestedPage > int64(totalPagesCount) {
-		http.Error(w, "requested page is invalid", http.StatusBadRequest)
-		log.Println("requested page is invalid")
-		return
-	}
-
-	sliceEnd := requestedPage * pageSize
-	if len(places) < int(sliceEnd) {
-		sliceEnd = int64(len(places))
-	}
-
-	fmt.Fprintln(w, buildPage(
-		totalPagesCount,
-		pageSize,
-		int(requestedPage),
-		places[(requestedPage-1)*pageSize:sliceEnd],
-	))
-}
-
-type invalidPageJson struct {
-	Error string `json:"error"`
-}
-
-type jsonResponse struct {
-	Name      string         `json:"name"`
-	Total     int            `json:"total"`
-	Places    []common.Place `json:"places"`
-	FirstPage *int           `json:"first_page,omitempty"`
-	PrevPage  *int           `json:"prev_page,omitempty"`
-	NextPage  *int           `json:"next_page,omitempty"`
-	LastPage  *int           `json:"last_page,omitempty"`
-}
-
-func (paginator *Paginator) returnJSON(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		http.Error(w, "not a get method", http.StatusMethodNotAllowed)
-		log.Println("not a get method")
-		return
+	var places []common.Place
+	var totalDocumentsCount int
+	var facets map[string][]paginate.FacetBucket
+	if openAt == nil {
+		places, totalDocumentsCount, err = paginator.ElasticPaginator.GetFilteredPlaces(math.MaxInt32, 0, filter)
+		if err == nil && len(facetFields) > 0 {
+			facets, err = paginator.ElasticPaginator.GetFacets(filter, facetFields)
+		}
+	} else {
+		// elasticsearch knows nothing about opening hours, so facets
+		// are counted in memory among places open at the moment
+		places, _, err = paginator.ElasticPaginator.GetFilteredPlaces(math.MaxInt32, 0, paginate.Filter{Search: filter.Search})
+		if err == nil {
+			places = filterOpen(places, *openAt)
+			if len(facetFields) > 0 {
+				facets = filter.CountFacets(places, facetFields)
+			}
+			places = filter.Apply(places)
+			totalDocumentsCount = len(places)
+		}
 	}
-
-	places, totalDocumentsCount, err := paginator.ElasticPaginator.GetPlaces(math.MaxInt32, 0)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		log.Println(err)
@@ -189,7 +111,8 @@ func (paginator *Paginator) returnJSON(w http.ResponseWriter, r *http.Request) {
 		totalPagesCount++
 	}
 
-	if requestedPage <= 0 || requestedPage > int64(totalPagesCount) {
+	// the first page of nothing is an empty list, e.g. when no place is open
+	if requestedPage <= 0 || (requestedPage > int64(totalPagesCount) && requestedPage != 1) {
 		marshalized, _ := json.MarshalIndent(
 			invalidPageJson{fmt.Sprintf("Invalid 'page' value: %v", requestedPage)},
 			"",
@@ -211,12 +134,13 @@ func (paginator *Paginator) returnJSON(w http.ResponseWriter, r *http.Request) {
 		Name:   "Places",
 		Total:  totalDocumentsCount,
 		Places: places,
+		Facets: facets,
 	}
 	if requestedPage != 1 && totalPagesCount != 1 {
 		response.FirstPage, response.PrevPage = new(int), new(int)
 		*response.FirstPage, *response.PrevPage = 1, int(requestedPage)-1
 	}
-	if requestedPage != int64(totalPagesCount) {
+	if requestedPage < int64(totalPagesCount) {
 		response.NextPage, response.LastPage = new(int), new(int)
 		*response.NextPage, *response.LastPage = int(requestedPage)+1, totalPagesCount
 	}
@@ -228,8 +152,9 @@ func (paginator *Paginator) returnJSON(w http.ResponseWriter, r *http.Request) {
 }
 
 type recommendResponse struct {
-	Name   string         `json:"name"`
-	Places []common.Place `json:"places"`
+	Name     string             `json:"name"`
+	Strategy string             `json:"strategy"`
+	Places   []recommendedPlace `json:"places"`
 }
 
 type geoSortEntry struct {
@@ -246,8 +171,10 @@ type geoDistance struct {
 }
 
 type sortSizeRequest struct {
-	Size int   `json:"size"`
-	Sort []any `json:"sort"`
+	Size        int   `json:"size"`
+	Query       any   `json:"query,omitempty"`
+	Sort        []any `json:"sort"`
+	SearchAfter []any `json:"search_after,omitempty"`
 }
 
 func constructGeoSortRequest(lon, lat float64, size int) sortSizeRequest {
@@ -266,6 +193,72 @@ func constructGeoSortRequest(lon, lat float64, size int) sortSizeRequest {
 		}}}}
 }
 
+// Places within the radius, the best rated first. Places without reviews
+// go last, places with the same rating are sorted by distance.
+func constructRatingSortRequest(lon, lat float64, size int, radius string) sortSizeRequest {
+	request := constructGeoSortRequest(lon, lat, size)
+	request.Query = map[string]any{"bool": map[string]any{"filter": map[string]any{
+		"geo_distance": map[string]any{
+			"distance": radius,
+			"location": common.Location{Latitude: lat, Longitude: lon},
+		},
+	}}}
+
+	// indices which weren't migrated to ratings have no rating fields yet
+	request.Sort = append([]any{
+		map[string]any{"rating_average": map[string]any{"order": "desc", "missing": "_last", "unmapped_type": "float"}},
+		map[string]any{"rating_count": map[string]any{"order": "desc", "missing": "_last", "unmapped_type": "integer"}},
+	}, request.Sort...)
+
+	return request
+}
+
+// radius of rating sort like "500m" or "2.5km"
+var radiusPattern = regexp.MustCompile(`^\d+(\.\d+)?(m|km)$`)
+
+const defaultRadius = "5km"
+
+// Places at the same distance have equal sort values, so search_after would skip or repeat
+// them at the boundary of batches. Sorting by id last makes the sort values of every place unique.
+func withIDTiebreaker(request sortSizeRequest) sortSizeRequest {
+	request.Sort = append(request.Sort, map[string]any{"id": map[string]any{"order": "asc", "unmapped_type": "long"}})
+	return request
+}
+
+// returns places in the order of the request, starting after the sort values of the previous batch if any
+func (paginator *Paginator) searchSorted(request sortSizeRequest, searchAfter []any) (*paginate.ElasticSortResponse, error) {
+	request.SearchAfter = searchAfter
+
+	marshalizedSort, _ := json.Marshal(request)
+	response, err := paginator.ElasticPaginator.Client.Search(
+		paginator.ElasticPaginator.Client.Search.WithIndex(paginator.ElasticPaginator.Index),
+		paginator.ElasticPaginator.Client.Search.WithBody(strings.NewReader(string(marshalizedSort))),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.IsError() {
+		return nil, fmt.Errorf("%s", response)
+	}
+
+	var res paginate.ElasticSortResponse
+	if err := json.NewDecoder(response.Body).Decode(&res); err != nil {
+		return nil, err
+	}
+
+	return &res, nil
+}
+
+const recommendSize = 3
+
+// nearest places are fetched in batches until enough of them are open
+const (
+	openCandidatesBatch = 50
+	maxOpenCandidates   = 1000
+)
+
 func (paginator *Paginator) recommendApi(w http.ResponseWriter, r *http.Request) {
 	encoder := json.NewEncoder(w)
 	encoder.SetIndent("", "  ")
@@ -282,141 +275,134 @@ func (paginator *Paginator) recommendApi(w http.ResponseWriter, r *http.Request)
 	var token string
 	fmt.Sscanf(r.Header.Get("Authorization"), "Bearer %s", &token)
 
-	if err := isTokenVerified(token); err != nil {
+	user, err := paginator.Tokens.tokenUser(token)
+	if err != nil {
 		w.WriteHeader(http.StatusUnauthorized)
 		encoder.Encode(invalidPageJson{"unathorized"})
 		log.Println(err)
 		return
 	}
 
-	lat, err := strconv.ParseFloat(r.URL.Query().Get("lat"), 64)
+	location, err := parseLocation(r)
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
-		encoder.Encode(invalidPageJson{fmt.Sprintf(`invalid "lat" value: %v`, lat)})
+		encoder.Encode(invalidPageJson{err.Error()})
 		return
 	}
+	lat, lon := location.Latitude, location.Longitude
 
-	lon, err := strconv.ParseFloat(r.URL.Query().Get("lon"), 64)
+	openAt, err := parseOpenFilter(r.URL.Query())
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
-		encoder.Encode(invalidPageJson{fmt.Sprintf(`invalid "lon" value: %v`, lon)})
-		return
-	}
-
-	marshalizedSort, _ := json.Marshal(constructGeoSortRequest(lon, lat, 3))
-	response, err := paginator.ElasticPaginator.Client.Search(
-		paginator.ElasticPaginator.Client.Search.WithIndex(paginator.ElasticPaginator.Index),
-		paginator.ElasticPaginator.Client.Search.WithBody(strings.NewReader(string(marshalizedSort))),
-	)
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
 		encoder.Encode(invalidPageJson{err.Error()})
 		return
 	}
-	if response.IsError() {
-		w.WriteHeader(http.StatusInternalServerError)
-		encoder.Encode(invalidPageJson{response.String()})
-	}
 
-	var res paginate.ElasticSortResponse
-	err = json.NewDecoder(response.Body).Decode(&res)
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		encoder.Encode(invalidPageJson{err.Error()})
-		return
+	batchSize := recommendSize
+	if openAt != nil {
+		batchSize = openCandidatesBatch
 	}
 
-	recommendResponse := recommendResponse{
-		Name: "Recommend",
+	radius := r.URL.Query().Get("radius")
+	if radius == "" {
+		radius = defaultRadius
 	}
-	recommendResponse.Places = make([]common.Place, len(res.Hits.Hits))
-	for i, hit := range res.Hits.Hits {
-		recommendResponse.Places[i] = hit.Source
-	}
-
-	encoder.Encode(recommendResponse)
-}
-
-func createToken(secret []byte) (string, error) {
-	token := jwt.New(jwt.SigningMethodHS256)
-
-	stringToken, err := token.SignedString(secret)
-	if err != nil {
-		return "", err
+	if !radiusPattern.MatchString(radius) {
+		w.WriteHeader(http.StatusBadRequest)
+		encoder.Encode(invalidPageJson{fmt.Sprintf(`invalid "radius" value: %v, expected e.g. 500m or 2km`, radius)})
+		return
 	}
 
-	return stringToken, nil
-}
-
-func isTokenVerified(token string) error {
-	t, err := jwt.Parse(
-		token,
-		func(token *jwt.Token) (any, error) {
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fmt.Errorf("error while formatting")
+	strategy := r.URL.Query().Get("strategy")
+	if strategy == "" {
+		strategy = strategyNearest
+	}
+	sortBy := r.URL.Query().Get("sort")
+
+	var request sortSizeRequest
+	var preferred preferences
+	switch strategy {
+	case strategyNearest:
+		switch sortBy {
+		case "", "distance":
+			request = constructGeoSortRequest(lon, lat, batchSize)
+		case "rating":
+			request = constructRatingSortRequest(lon, lat, batchSize, radius)
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			encoder.Encode(invalidPageJson{fmt.Sprintf(`invalid "sort" value: %v, expected "distance" or "rating"`, sortBy)})
+			return
+		}
+	case strategyPersonalized, strategyPopular:
+		if sortBy != "" {
+			w.WriteHeader(http.StatusBadRequest)
+			encoder.Encode(invalidPageJson{fmt.Sprintf(`"sort" applies to the %q strategy only`, strategyNearest)})
+			return
+		}
+
+		if strategy == strategyPersonalized {
+			if user == "" {
+				w.WriteHeader(http.StatusForbidden)
+				encoder.Encode(invalidPageJson{"token has no user, get one from /api/get_token with basic authentication"})
+				return
 			}
 
-			return []byte("SomeSuperSecretKey"), nil
-		},
-	)
-
-	if err != nil {
-		return err
-	}
-
-	if t.Valid {
-		return nil
-	}
-
-	return fmt.Errorf("unathorized")
-}
-
-func getToken(w http.ResponseWriter, r *http.Request) {
-	encoder := json.NewEncoder(w)
-	encoder.SetIndent("", "  ")
+			if preferred, err = paginator.userPreferences(user); err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				encoder.Encode(invalidPageJson{err.Error()})
+				log.Println(err)
+				return
+			}
 
-	w.Header().Add("Content-Type", "application/json")
+			// nothing is known about the user until they save some places
+			if preferred.isEmpty() {
+				strategy = strategyPopular
+			}
+		}
 
-	if r.Method != http.MethodGet {
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		encoder.Encode(invalidPageJson{"not a GET method"})
-		log.Println("not a get method")
+		request = constructScoredRequest(lon, lat, batchSize, radius, strategy, preferred)
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		encoder.Encode(invalidPageJson{fmt.Sprintf(`invalid "strategy" value: %v, expected %q, %q or %q`, strategy, strategyNearest, strategyPersonalized, strategyPopular)})
 		return
 	}
 
-	type response struct {
-		Token string `json:"token"`
-	}
+	recommendResponse := recommendResponse{
+		Name:     "Recommend",
+		Strategy: strategy,
+		Places:   make([]recommendedPlace, 0, recommendSize),
+	}
+
+	request = withIDTiebreaker(request)
+	var searchAfter []any
+	for fetched := 0; len(recommendResponse.Places) < recommendSize && fetched < maxOpenCandidates; {
+		res, err := paginator.searchSorted(request, searchAfter)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			encoder.Encode(invalidPageJson{err.Error()})
+			log.Println(err)
+			return
+		}
+
+		for _, hit := range res.Hits.Hits {
+			if len(recommendResponse.Places) < recommendSize && (openAt == nil || isOpen(hit.Source, *openAt)) {
+				distance := hitDistance(hit.Sort)
+				recommendResponse.Places = append(recommendResponse.Places, recommendedPlace{
+					Place:      hit.Source,
+					DistanceKm: distance,
+					Reason:     recommendReason(hit.Source, distance, strategy, sortBy, preferred),
+				})
+			}
+		}
 
-	token, err := createToken([]byte("SomeSuperSecretKey"))
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		encoder.Encode(invalidPageJson{"error while creating token"})
-		log.Println(err)
-		return
+		if openAt == nil || len(res.Hits.Hits) < batchSize {
+			break
+		}
+		fetched += len(res.Hits.Hits)
+		searchAfter = res.Hits.Hits[len(res.Hits.Hits)-1].Sort
 	}
 
-	encoder.Encode(response{Token: token})
-
-	// token, err := jwt.Parse(
-	// 	r.Header.Get("Token"),
-	// 	func(token *jwt.Token) (any, error) {
-	// 		if _, ok := token.Method.(*jwt.SigningMethodECDSA); !ok {
-	// 			http.Error(w, "", http.StatusUnauthorized)
-	// 			err := encoder.Encode(`{"error": "unathorized"}`)
-	// 			if err != nil {
-	// 				return nil, err
-	// 			}
-	// 		}
-
-	// 		return "", nil
-	// 	},
-	// )
-	// if err != nil {
-	// 	http.Error(w, "", http.StatusUnauthorized)
-	// 	encoder.Encode(`{"error": "unathorized due to parsin error}`)
-	// 	return
-	// }
+	encoder.Encode(recommendResponse)
 }
 
 func main() {
@@ -429,6 +415,36 @@ func main() {
 			DefaultValue: "",
 			Required:     true,
 		},
+		args.Arg{
+			Name:         "index",
+			Description:  "Name of the index or alias places are read from",
+			DefaultValue: "places",
+			Required:     false,
+		},
+		args.Arg{
+			Name:         "lists-index",
+			Description:  "Name of the index favorites and lists of users are stored in, created if it doesn't exist",
+			DefaultValue: "lists",
+			Required:     false,
+		},
+		args.Arg{
+			Name:         "reviews-index",
+			Description:  "Name of the index reviews of places are stored in, created if it doesn't exist",
+			DefaultValue: "reviews",
+			Required:     false,
+		},
+		args.Arg{
+			Name:         "users",
+			Description:  "Path to the file of users allowed to get a personal token, one \"name:bcrypt hash\" per line as written by htpasswd -nBC 12 name",
+			DefaultValue: "",
+			Required:     false,
+		},
+		args.Arg{
+			Name:         "token-ttl",
+			Description:  "Lifetime of issued tokens, e.g. 30m or 24h",
+			DefaultValue: "24h",
+			Required:     false,
+		},
 	)
 	if err != nil {
 		log.Fatalln(err)
@@ -439,18 +455,50 @@ func main() {
 		log.Fatalln(err)
 	}
 
+	tokenTTL, err := time.ParseDuration(parsedArgs["token-ttl"].(string))
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	// the secret isn't a flag, so it doesn't show up in the process list
+	tokens, err := newTokenIssuer(os.Getenv(secretVariable), tokenTTL, parsedArgs["users"].(string))
+	if err != nil {
+		log.Fatalln(err)
+	}
+
 	client, err := db.CreateClient(CACert)
 	if err != nil {
 		log.Fatalln(err)
 	}
 
-	paginator := Paginator{paginate.ElasticPaginator{Client: client, Index: "places"}}
+	paginator := Paginator{
+		ElasticPaginator: paginate.ElasticPaginator{Client: client, Index: parsedArgs["index"].(string)},
+		Reviews: paginate.ElasticReviews{
+			Client:      client,
+			Index:       parsedArgs["reviews-index"].(string),
+			PlacesIndex: parsedArgs["index"].(string),
+		},
+		Tokens: tokens,
+	}
+	if err := paginator.Reviews.EnsureIndex(); err != nil {
+		log.Fatalln(err)
+	}
+
+	lists := &paginate.ElasticLists{Client: client, Index: parsedArgs["lists-index"].(string)}
+	if err := lists.EnsureIndex(); err != nil {
+		log.Fatalln(err)
+	}
+	paginator.Lists = lists
 
 	// handlers
 	http.HandleFunc("/", paginator.showPage)
+	http.HandleFunc("/places/", paginator.showPlace)
 	http.HandleFunc("/api/places", paginator.returnJSON)
+	http.HandleFunc("/api/places/", paginator.placeApi)
+	http.HandleFunc("/api/me/", paginator.meApi)
 	http.HandleFunc("/api/recommend", paginator.recommendApi)
-	http.HandleFunc("/api/get_token", getToken)
+	http.HandleFunc("/api/recommend/route", paginator.routeApi)
+	http.HandleFunc("/api/get_token", paginator.Tokens.getToken)
 
 	// server itself
 	err = http.ListenAndServe(":8888", nil)
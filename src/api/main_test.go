@@ -0,0 +1,41 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"paginate"
+	"testing"
+
+	"github.com/elastic/go-elasticsearch/v8"
+)
+
+// parameters are checked before anything is fetched, so a bad request costs no search
+func TestReturnJSONRejectsInvalidParametersWithoutSearching(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("unexpected request to elasticsearch: %s %s", r.Method, r.URL)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	client, err := elasticsearch.NewClient(elasticsearch.Config{Addresses: []string{server.URL}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	paginator := Paginator{ElasticPaginator: paginate.ElasticPaginator{Client: client, Index: "places"}}
+
+	tests := []string{
+		"/api/places?page=1&open_at=tomorrow",
+		"/api/places?page=1&open_now=sometimes",
+		"/api/places?page=1&price_level=9",
+		"/api/places?page=1&facets=name",
+	}
+
+	for _, target := range tests {
+		recorder := httptest.NewRecorder()
+		paginator.returnJSON(recorder, httptest.NewRequest(http.MethodGet, target, nil))
+
+		if recorder.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want %d", target, recorder.Code, http.StatusBadRequest)
+		}
+	}
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"bytes"
+	"common"
+	"embed"
+	"fmt"
+	"html/template"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//go:embed templates/*.html
+var templateFiles embed.FS
+
+var templateFunctions = template.FuncMap{
+	"join": strings.Join,
+	"price": func(level int) string {
+		return strings.Repeat("₽", level)
+	},
+	// other schemes, like javascript:, are never linked
+	"isWebLink": func(website string) bool {
+		return strings.HasPrefix(website, "http://") || strings.HasPrefix(website, "https://")
+	},
+}
+
+// every page is the layout filled with its own "title" and "content"
+var (
+	placesTemplate = parsePageTemplate("places.html")
+	placeTemplate  = parsePageTemplate("place.html")
+)
+
+func parsePageTemplate(name string) *template.Template {
+	return template.Must(
+		template.New(name).Funcs(templateFunctions).ParseFS(templateFiles, "templates/layout.html", "templates/"+name),
+	)
+}
+
+type queryValue struct {
+	Name  string
+	Value string
+}
+
+// search box keeps the filters of the current page, while a new search starts from the first page
+type layoutView struct {
+	Search string
+	Hidden []queryValue
+}
+
+func newLayoutView(query url.Values) layoutView {
+	view := layoutView{Search: query.Get("q")}
+	for name, values := range query {
+		if name == "q" || name == "page" {
+			continue
+		}
+		for _, value := range values {
+			view.Hidden = append(view.Hidden, queryValue{Name: name, Value: value})
+		}
+	}
+
+	// the order of map iteration is random, while the page should be the same every time
+	sort.SliceStable(view.Hidden, func(i, j int) bool { return view.Hidden[i].Name < view.Hidden[j].Name })
+	return view
+}
+
+type linkView struct {
+	Name string
+	URL  string
+}
+
+type placeEntryView struct {
+	common.Place
+	URL string
+}
+
+type placesPageView struct {
+	layoutView
+	Total   int
+	Page    int
+	Places  []placeEntryView
+	Buttons []linkView
+}
+
+type placePageView struct {
+	layoutView
+	Place        common.Place
+	BackURL      string
+	Reviews      []common.Review
+	ReviewsTotal int
+}
+
+// link to the page of the list, keeping the search, filters and anything else of the query
+func pageURL(query url.Values, page int) string {
+	linkQuery := url.Values{}
+	for name, values := range query {
+		linkQuery[name] = values
+	}
+	linkQuery.Set("page", strconv.Itoa(page))
+
+	return (&url.URL{Path: "/", RawQuery: linkQuery.Encode()}).String()
+}
+
+// renders into a buffer first, so a failing template results in an error instead of half a page
+func renderPage(w http.ResponseWriter, page *template.Template, view any) {
+	var buffer bytes.Buffer
+	if err := page.ExecuteTemplate(&buffer, "layout", view); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		log.Println(err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	buffer.WriteTo(w)
+}
+
+// lists places accepting the same search and filters as "/api/places"
+func (paginator *Paginator) showPage(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "not a get method", http.StatusMethodNotAllowed)
+		log.Println("not a get method")
+		return
+	}
+
+	query := r.URL.Query()
+
+	filter, _, err := parsePlaceFilter(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	openAt, err := parseOpenFilter(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	requestedPage := 1
+	if value := query.Get("page"); value != "" {
+		if requestedPage, err = strconv.Atoi(value); err != nil {
+			http.Error(w, fmt.Sprintf("invalid page %q", value), http.StatusBadRequest)
+			return
+		}
+	}
+
+	places, totalDocumentsCount, err := paginator.ElasticPaginator.GetFilteredPlaces(math.MaxInt32, 0, filter)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		log.Println(err)
+		return
+	}
+	if openAt != nil {
+		places = filterOpen(places, *openAt)
+		totalDocumentsCount = len(places)
+	}
+
+	totalPagesCount := totalDocumentsCount / pageSize
+	if totalDocumentsCount%pageSize != 0 {
+		totalPagesCount++
+	}
+
+	// the first page of nothing shows that nothing is found
+	if requestedPage <= 0 || (requestedPage > totalPagesCount && requestedPage != 1) {
+		http.Error(w, "requested page is invalid", http.StatusBadRequest)
+		log.Println("requested page is invalid")
+		return
+	}
+
+	sliceEnd := requestedPage * pageSize
+	if len(places) < sliceEnd {
+		sliceEnd = len(places)
+	}
+
+	view := placesPageView{
+		layoutView: newLayoutView(query),
+		Total:      totalPagesCount,
+		Page:       requestedPage,
+	}
+
+	// details keep the query, so going back returns to the same page of the list
+	detailsQuery := url.Values{}
+	for name, values := range query {
+		detailsQuery[name] = values
+	}
+	detailsQuery.Set("page", strconv.Itoa(requestedPage))
+
+	for _, place := range places[(requestedPage-1)*pageSize : sliceEnd] {
+		details := url.URL{Path: fmt.Sprintf("/places/%d", place.ID), RawQuery: detailsQuery.Encode()}
+		view.Places = append(view.Places, placeEntryView{Place: place, URL: details.String()})
+	}
+
+	if requestedPage != 1 && totalPagesCount > 1 {
+		view.Buttons = append(view.Buttons,
+			linkView{Name: "First", URL: pageURL(query, 1)},
+			linkView{Name: "Previous", URL: pageURL(query, requestedPage-1)},
+		)
+	}
+	if requestedPage < totalPagesCount {
+		view.Buttons = append(view.Buttons,
+			linkView{Name: "Next", URL: pageURL(query, requestedPage+1)},
+			linkView{Name: "Last", URL: pageURL(query, totalPagesCount)},
+		)
+	}
+
+	renderPage(w, placesTemplate, view)
+}
+
+// shows "/places/{id}" with the latest reviews, ids of merged duplicates are redirected
+func (paginator *Paginator) showPlace(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "not a get method", http.StatusMethodNotAllowed)
+		log.Println("not a get method")
+		return
+	}
+
+	placeID, err := strconv.ParseUint(strings.TrimPrefix(r.URL.Path, "/places/"), 10, 64)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	place, err := paginator.ElasticPaginator.GetPlace(placeID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		log.Println(err)
+		return
+	}
+	if place == nil {
+		canonical, err := paginator.ElasticPaginator.GetCanonicalPlace(placeID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			log.Println(err)
+			return
+		}
+		if canonical == nil {
+			http.NotFound(w, r)
+			return
+		}
+
+		location := url.URL{Path: fmt.Sprintf("/places/%d", canonical.ID), RawQuery: r.URL.RawQuery}
+		http.Redirect(w, r, location.String(), http.StatusMovedPermanently)
+		return
+	}
+
+	reviews, reviewsTotal, err := paginator.Reviews.GetReviews(placeID, reviewsPageSize, 0)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		log.Println(err)
+		return
+	}
+
+	back := url.URL{Path: "/", RawQuery: r.URL.RawQuery}
+	renderPage(w, placeTemplate, placePageView{
+		layoutView:   newLayoutView(r.URL.Query()),
+		Place:        *place,
+		BackURL:      back.String(),
+		Reviews:      reviews,
+		ReviewsTotal: reviewsTotal,
+	})
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"common"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"paginate"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8"
+)
+
+// Place, review and search crafted to break out of the page. Everything of them
+// is escaped on the page, while links keep the search percent-encoded.
+func TestPagesEscapeUserContent(t *testing.T) {
+	place := common.Place{
+		ID:       7,
+		Name:     `<script>alert("name")</script>`,
+		Address:  `"><img src=x onerror=alert(1)>`,
+		Phone:    "+74956765535",
+		Location: common.Location{Latitude: 55.75, Longitude: 37.6},
+		Website:  "javascript:alert(document.cookie)",
+	}
+	review := common.Review{PlaceID: 7, User: "bob", Rating: 5, Text: `</div><script>alert("review")</script>`, CreatedAt: time.Now()}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Elastic-Product", "Elasticsearch")
+		w.Header().Set("Content-Type", "application/json")
+
+		body, _ := io.ReadAll(r.Body)
+		switch {
+		case r.URL.Path == "/places/_doc/7":
+			json.NewEncoder(w).Encode(map[string]any{"found": true, "_source": place})
+		case r.URL.Path == "/reviews/_search":
+			json.NewEncoder(w).Encode(map[string]any{"hits": map[string]any{
+				"total": map[string]any{"value": 1},
+				"hits":  []any{map[string]any{"_source": review}},
+			}})
+		case r.URL.Path == "/places/_search" && !strings.Contains(string(body), "search_after"):
+			json.NewEncoder(w).Encode(map[string]any{"hits": map[string]any{
+				"total": map[string]any{"value": 1},
+				"hits":  []any{map[string]any{"_source": place, "sort": []any{7}}},
+			}})
+		case r.URL.Path == "/places/_search":
+			w.Write([]byte(`{"hits": {"total": {"value": 0}, "hits": []}}`))
+		default:
+			t.Errorf("unexpected request to elasticsearch: %s %s", r.Method, r.URL)
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	client, err := elasticsearch.NewClient(elasticsearch.Config{Addresses: []string{server.URL}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	paginator := Paginator{
+		ElasticPaginator: paginate.ElasticPaginator{Client: client, Index: "places"},
+		Reviews:          paginate.ElasticReviews{Client: client, Index: "reviews", PlacesIndex: "places"},
+	}
+
+	search := `"><script>alert('q')</script>`
+	query := url.Values{"q": {search}, "page": {"1"}}.Encode()
+
+	tests := []struct {
+		name    string
+		target  string
+		handler http.HandlerFunc
+		escaped []string
+		link    string
+	}{
+		{
+			name:    "list",
+			target:  "/?" + query,
+			handler: paginator.showPage,
+			escaped: []string{`&lt;script&gt;alert(&#34;name&#34;)&lt;/script&gt;`, `&#34;&gt;&lt;img src=x onerror=alert(1)&gt;`},
+			link:    "/places/7?" + query,
+		},
+		{
+			name:    "place",
+			target:  "/places/7?" + query,
+			handler: paginator.showPlace,
+			escaped: []string{
+				`&lt;script&gt;alert(&#34;name&#34;)&lt;/script&gt;`,
+				`&#34;&gt;&lt;img src=x onerror=alert(1)&gt;`,
+				`&lt;/div&gt;&lt;script&gt;alert(&#34;review&#34;)&lt;/script&gt;`,
+			},
+			link: "/?" + query,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			test.handler(recorder, httptest.NewRequest(http.MethodGet, test.target, nil))
+
+			if recorder.Code != http.StatusOK {
+				t.Fatalf("status = %d: %s", recorder.Code, recorder.Body)
+			}
+			page := recorder.Body.String()
+
+			for _, unsafe := range []string{"<script>", "<img", "javascript:", search} {
+				if strings.Contains(page, unsafe) {
+					t.Errorf("page contains %q:\n%s", unsafe, page)
+				}
+			}
+			for _, escaped := range test.escaped {
+				if !strings.Contains(page, escaped) {
+					t.Errorf("page doesn't contain %q:\n%s", escaped, page)
+				}
+			}
+
+			// the search box shows the search as it was typed
+			if want := `value="&#34;&gt;&lt;script&gt;alert(&#39;q&#39;)&lt;/script&gt;"`; !strings.Contains(page, want) {
+				t.Errorf("search box doesn't contain %q:\n%s", want, page)
+			}
+			if want := `href="` + strings.ReplaceAll(test.link, "&", "&amp;") + `"`; !strings.Contains(page, want) {
+				t.Errorf("page doesn't link %q:\n%s", want, page)
+			}
+		})
+	}
+}
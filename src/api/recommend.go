@@ -0,0 +1,217 @@
+package main
+
+import (
+	"common"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// strategies of recommendations
+const (
+	strategyNearest      = "nearest"
+	strategyPersonalized = "personalized"
+	strategyPopular      = "popular"
+)
+
+// number of the most liked cuisines personalized recommendations boost
+const maxPreferredCuisines = 5
+
+type recommendedPlace struct {
+	common.Place
+	DistanceKm float64 `json:"distance_km"`
+	Reason     string  `json:"reason"`
+}
+
+// What a user likes, learned from places in their favorites and lists.
+// Cuisines are weighted by the share of liked places serving them.
+type preferences struct {
+	Cuisines   map[string]float64
+	PriceLevel int
+}
+
+func (preferences preferences) isEmpty() bool {
+	return len(preferences.Cuisines) == 0 && preferences.PriceLevel == 0
+}
+
+func (paginator *Paginator) userPreferences(user string) (preferences, error) {
+	lists, err := paginator.Lists.GetLists(user)
+	if err != nil {
+		return preferences{}, err
+	}
+
+	var ids []uint64
+	seen := make(map[uint64]bool)
+	for _, list := range lists {
+		for _, id := range list.PlaceIDs {
+			if !seen[id] {
+				seen[id] = true
+				ids = append(ids, id)
+			}
+		}
+	}
+
+	places, err := paginator.ElasticPaginator.GetPlacesByIDs(ids)
+	if err != nil {
+		return preferences{}, err
+	}
+
+	return learnPreferences(places), nil
+}
+
+func learnPreferences(places []common.Place) preferences {
+	cuisineCounts := make(map[string]int)
+	priceCounts := make(map[int]int)
+	for _, place := range places {
+		for _, cuisine := range place.Cuisine {
+			cuisineCounts[cuisine]++
+		}
+		if place.PriceLevel > 0 {
+			priceCounts[place.PriceLevel]++
+		}
+	}
+
+	cuisines := make([]string, 0, len(cuisineCounts))
+	for cuisine := range cuisineCounts {
+		cuisines = append(cuisines, cuisine)
+	}
+	sort.Slice(cuisines, func(i, j int) bool {
+		if cuisineCounts[cuisines[i]] != cuisineCounts[cuisines[j]] {
+			return cuisineCounts[cuisines[i]] > cuisineCounts[cuisines[j]]
+		}
+		return cuisines[i] < cuisines[j]
+	})
+	if len(cuisines) > maxPreferredCuisines {
+		cuisines = cuisines[:maxPreferredCuisines]
+	}
+
+	learned := preferences{Cuisines: make(map[string]float64, len(cuisines))}
+	for _, cuisine := range cuisines {
+		learned.Cuisines[cuisine] = float64(cuisineCounts[cuisine]) / float64(len(places))
+	}
+
+	// the most frequent price level, the cheaper one of equally frequent
+	for level := 1; level <= common.MaxPriceLevel; level++ {
+		if priceCounts[level] > priceCounts[learned.PriceLevel] {
+			learned.PriceLevel = level
+		}
+	}
+
+	return learned
+}
+
+// Scores places by the value of a rating field. Indices which weren't migrated to ratings
+// have no such field, which field_value_factor fails on, so the function applies only
+// to places having it, the same as if the value of the others were 0.
+func ratingFunction(factor map[string]any) map[string]any {
+	factor["missing"] = 0
+	return map[string]any{
+		"filter":             map[string]any{"exists": map[string]any{"field": factor["field"]}},
+		"field_value_factor": factor,
+		"weight":             1,
+	}
+}
+
+// Places within the radius scored by function_score, the best first. Distance
+// decays by half at the radius, so far places need to be much better rated
+// or much closer to the preferences to outscore near ones.
+func constructScoredRequest(lon, lat float64, size int, radius string, strategy string, preferred preferences) sortSizeRequest {
+	location := common.Location{Latitude: lat, Longitude: lon}
+
+	functions := []any{
+		map[string]any{
+			"gauss":  map[string]any{"location": map[string]any{"origin": location, "scale": radius, "decay": 0.5}},
+			"weight": 1,
+		},
+		ratingFunction(map[string]any{"field": "rating_average", "factor": 1.0 / common.MaxRating}),
+	}
+
+	switch strategy {
+	case strategyPopular:
+		functions = append(functions, ratingFunction(map[string]any{"field": "rating_count", "modifier": "log1p"}))
+	case strategyPersonalized:
+		cuisines := make([]string, 0, len(preferred.Cuisines))
+		for cuisine := range preferred.Cuisines {
+			cuisines = append(cuisines, cuisine)
+		}
+		sort.Strings(cuisines)
+
+		for _, cuisine := range cuisines {
+			functions = append(functions, map[string]any{
+				"filter": map[string]any{"term": map[string]any{"cuisine": cuisine}},
+				"weight": preferred.Cuisines[cuisine],
+			})
+		}
+		if preferred.PriceLevel > 0 {
+			functions = append(functions, map[string]any{
+				"filter": map[string]any{"term": map[string]any{"price_level": preferred.PriceLevel}},
+				"weight": 0.5,
+			})
+		}
+	}
+
+	request := constructGeoSortRequest(lon, lat, size)
+	request.Query = map[string]any{"function_score": map[string]any{
+		"query": map[string]any{"bool": map[string]any{"filter": map[string]any{
+			"geo_distance": map[string]any{"distance": radius, "location": location},
+		}}},
+		"functions":  functions,
+		"score_mode": "sum",
+		"boost_mode": "replace",
+	}}
+	request.Sort = append([]any{map[string]any{"_score": map[string]any{"order": "desc"}}}, request.Sort...)
+
+	return request
+}
+
+// every request sorts by distance and then by id, so it's the sort value before the last one
+func hitDistance(sortValues []any) float64 {
+	if len(sortValues) < 2 {
+		return 0
+	}
+	distance, _ := sortValues[len(sortValues)-2].(float64)
+	return math.Round(distance*100) / 100
+}
+
+func ratingReason(place common.Place) string {
+	if place.RatingCount == 0 {
+		return "no reviews yet"
+	}
+	return fmt.Sprintf("rated %.1f from %d reviews", place.RatingAverage, place.RatingCount)
+}
+
+// explains a recommendation in the terms of the strategy which made it
+func recommendReason(place common.Place, distance float64, strategy, sortBy string, preferred preferences) string {
+	away := fmt.Sprintf("%.2f km away", distance)
+
+	switch strategy {
+	case strategyPopular:
+		return fmt.Sprintf("popular nearby: %s, %s", ratingReason(place), away)
+	case strategyPersonalized:
+		var reasons []string
+
+		var matched []string
+		for _, cuisine := range place.Cuisine {
+			if _, ok := preferred.Cuisines[cuisine]; ok {
+				matched = append(matched, cuisine)
+			}
+		}
+		if len(matched) > 0 {
+			reasons = append(reasons, fmt.Sprintf("serves %s you like", strings.Join(matched, ", ")))
+		}
+		if preferred.PriceLevel > 0 && place.PriceLevel == preferred.PriceLevel {
+			reasons = append(reasons, "has your usual prices")
+		}
+		if len(reasons) == 0 {
+			reasons = append(reasons, "well rated nearby")
+		}
+
+		return fmt.Sprintf("%s, %s, %s", strings.Join(reasons, " and "), ratingReason(place), away)
+	}
+
+	if sortBy == "rating" {
+		return fmt.Sprintf("among the best rated nearby: %s, %s", ratingReason(place), away)
+	}
+	return fmt.Sprintf("one of the nearest, %s", away)
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"common"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// open places behind a batch of closed ones at the very same spot are still found
+func TestRecommendApiPagesThroughPlacesAtTheSameDistance(t *testing.T) {
+	location := common.Location{Latitude: 55.75, Longitude: 37.6}
+	openHours := &common.OpeningHours{Weekly: []common.OpeningInterval{{Day: time.Monday, Open: "09:00", Close: "18:00"}}}
+
+	places := make([]common.Place, openCandidatesBatch+10)
+	for i := range places {
+		places[i] = common.Place{ID: uint64(i + 1), Name: fmt.Sprintf("place %d", i+1), Location: location}
+		if i >= openCandidatesBatch {
+			places[i].OpeningHours = openHours
+		}
+	}
+	search, paginator := newSortedSearch(t, places, 0.25)
+
+	token, err := paginator.Tokens.createToken("", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	request := httptest.NewRequest(http.MethodGet, "/api/recommend?lat=55.75&lon=37.6&open_at=2026-10-19T12:00", nil)
+	request.Header.Set("Authorization", "Bearer "+token)
+	recorder := httptest.NewRecorder()
+
+	paginator.recommendApi(recorder, request)
+
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", recorder.Code, recorder.Body)
+	}
+	var response recommendResponse
+	if err := json.NewDecoder(recorder.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(response.Places) != recommendSize {
+		t.Fatalf("%d places are recommended, want %d", len(response.Places), recommendSize)
+	}
+	for i, place := range response.Places {
+		if want := uint64(openCandidatesBatch + i + 1); place.ID != want {
+			t.Errorf("place %d is %d, want %d", i, place.ID, want)
+		}
+		if place.DistanceKm != 0.25 {
+			t.Errorf("distance of place %d = %v, want 0.25", place.ID, place.DistanceKm)
+		}
+	}
+	for id, count := range search.returned {
+		if count != 1 {
+			t.Errorf("place %d is fetched %d times", id, count)
+		}
+	}
+}
+
+// functions and sorts of every strategy, as elasticsearch receives them
+func TestRecommendRequests(t *testing.T) {
+	preferred := preferences{Cuisines: map[string]float64{"georgian": 0.5}, PriceLevel: 2}
+
+	tests := []struct {
+		strategy  string
+		request   sortSizeRequest
+		functions []string // fields of field_value_factor and terms of filters
+		firstSort string
+	}{
+		{
+			strategy:  strategyNearest,
+			request:   constructGeoSortRequest(37.6, 55.75, recommendSize),
+			firstSort: "_geo_distance",
+		},
+		{
+			strategy:  strategyNearest + " by rating",
+			request:   constructRatingSortRequest(37.6, 55.75, recommendSize, defaultRadius),
+			firstSort: "rating_average",
+		},
+		{
+			strategy:  strategyPopular,
+			request:   constructScoredRequest(37.6, 55.75, recommendSize, defaultRadius, strategyPopular, preferences{}),
+			functions: []string{"gauss", "rating_average", "rating_count"},
+			firstSort: "_score",
+		},
+		{
+			strategy:  strategyPersonalized,
+			request:   constructScoredRequest(37.6, 55.75, recommendSize, defaultRadius, strategyPersonalized, preferred),
+			functions: []string{"gauss", "rating_average", "cuisine=georgian", "price_level=2"},
+			firstSort: "_score",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.strategy, func(t *testing.T) {
+			marshalizedRequest, err := json.Marshal(test.request)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var request struct {
+				Query struct {
+					FunctionScore struct {
+						Functions []map[string]json.RawMessage `json:"functions"`
+					} `json:"function_score"`
+				} `json:"query"`
+				Sort []map[string]any `json:"sort"`
+			}
+			if err := json.Unmarshal(marshalizedRequest, &request); err != nil {
+				t.Fatal(err)
+			}
+
+			var functions []string
+			for _, function := range request.Query.FunctionScore.Functions {
+				var factor struct {
+					Field string `json:"field"`
+				}
+				var filter struct {
+					Exists struct {
+						Field string `json:"field"`
+					} `json:"exists"`
+					Term map[string]any `json:"term"`
+				}
+				json.Unmarshal(function["field_value_factor"], &factor)
+				json.Unmarshal(function["filter"], &filter)
+
+				switch {
+				case function["gauss"] != nil:
+					functions = append(functions, "gauss")
+				case factor.Field != "":
+					// indices without ratings would fail the whole search
+					if filter.Exists.Field != factor.Field {
+						t.Errorf("factor of %q applies to places with %q", factor.Field, filter.Exists.Field)
+					}
+					functions = append(functions, factor.Field)
+				default:
+					for field, value := range filter.Term {
+						functions = append(functions, fmt.Sprintf("%s=%v", field, value))
+					}
+				}
+			}
+			if fmt.Sprint(functions) != fmt.Sprint(test.functions) {
+				t.Errorf("functions = %q, want %q", functions, test.functions)
+			}
+
+			if _, ok := request.Sort[0][test.firstSort]; !ok {
+				t.Errorf("first sort = %v, want %q", request.Sort[0], test.firstSort)
+			}
+		})
+	}
+}
+
+func TestRecommendReason(t *testing.T) {
+	preferred := preferences{Cuisines: map[string]float64{"georgian": 0.5}, PriceLevel: 2}
+	rated := common.Place{Cuisine: []string{"georgian", "european"}, PriceLevel: 2, RatingAverage: 4.5, RatingCount: 2}
+	unrated := common.Place{Cuisine: []string{"italian"}, PriceLevel: 3}
+
+	tests := []struct {
+		strategy string
+		sortBy   string
+		place    common.Place
+		want     string
+	}{
+		{strategy: strategyNearest, place: rated, want: "one of the nearest, 0.25 km away"},
+		{strategy: strategyNearest, sortBy: "rating", place: rated, want: "among the best rated nearby: rated 4.5 from 2 reviews, 0.25 km away"},
+		{strategy: strategyPopular, place: rated, want: "popular nearby: rated 4.5 from 2 reviews, 0.25 km away"},
+		{strategy: strategyPopular, place: unrated, want: "popular nearby: no reviews yet, 0.25 km away"},
+		{strategy: strategyPersonalized, place: rated, want: "serves georgian you like and has your usual prices, rated 4.5 from 2 reviews, 0.25 km away"},
+		{strategy: strategyPersonalized, place: unrated, want: "well rated nearby, no reviews yet, 0.25 km away"},
+	}
+
+	for _, test := range tests {
+		if got := recommendReason(test.place, 0.25, test.strategy, test.sortBy, preferred); got != test.want {
+			t.Errorf("%s %s: reason = %q, want %q", test.strategy, test.sortBy, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"common"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	reviewsPageSize = 10
+	maxReviewLength = 5000
+)
+
+type reviewRequest struct {
+	Rating int    `json:"rating"`
+	Text   string `json:"text"`
+}
+
+type reviewsResponse struct {
+	Name     string          `json:"name"`
+	PlaceID  uint64          `json:"place_id"`
+	Total    int             `json:"total"`
+	Reviews  []common.Review `json:"reviews"`
+	PrevPage *int            `json:"prev_page,omitempty"`
+	NextPage *int            `json:"next_page,omitempty"`
+}
+
+// Routes "/api/places/{id}" and "/api/places/{id}/reviews", since ServeMux
+// of go 1.21 doesn't support wildcards in patterns. Ids of duplicates merged
+// into another place are redirected to it.
+func (paginator *Paginator) placeApi(w http.ResponseWriter, r *http.Request) {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+
+	w.Header().Add("Content-Type", "application/json")
+
+	idValue, resource, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/api/places/"), "/")
+	placeID, err := strconv.ParseUint(idValue, 10, 64)
+	if err != nil || (resource != "" && resource != "reviews") {
+		w.WriteHeader(http.StatusNotFound)
+		encoder.Encode(invalidPageJson{"not found"})
+		return
+	}
+
+	place, err := paginator.ElasticPaginator.GetPlace(placeID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		encoder.Encode(invalidPageJson{err.Error()})
+		log.Println(err)
+		return
+	}
+	if place == nil {
+		paginator.redirectMerged(w, r, encoder, placeID, resource)
+		return
+	}
+
+	if resource == "" {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			encoder.Encode(invalidPageJson{"not a GET method"})
+			log.Println("not a get method")
+			return
+		}
+
+		encoder.Encode(place)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		paginator.listReviews(w, r, encoder, placeID)
+	case http.MethodPost:
+		paginator.addReview(w, r, encoder, placeID)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		encoder.Encode(invalidPageJson{"not a GET or POST method"})
+		log.Println("not a get or post method")
+	}
+}
+
+// the redirect keeps the method, so reviews posted to a merged place reach the canonical one
+func (paginator *Paginator) redirectMerged(w http.ResponseWriter, r *http.Request, encoder *json.Encoder, placeID uint64, resource string) {
+	canonical, err := paginator.ElasticPaginator.GetCanonicalPlace(placeID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		encoder.Encode(invalidPageJson{err.Error()})
+		log.Println(err)
+		return
+	}
+	if canonical == nil {
+		w.WriteHeader(http.StatusNotFound)
+		encoder.Encode(invalidPageJson{fmt.Sprintf("there is no place with id %d", placeID)})
+		return
+	}
+
+	location := url.URL{Path: fmt.Sprintf("/api/places/%d", canonical.ID), RawQuery: r.URL.RawQuery}
+	if resource != "" {
+		location.Path += "/" + resource
+	}
+
+	w.Header().Set("Location", location.String())
+	w.WriteHeader(http.StatusPermanentRedirect)
+	encoder.Encode(struct {
+		MergedInto uint64 `json:"merged_into"`
+	}{canonical.ID})
+}
+
+func (paginator *Paginator) listReviews(w http.ResponseWriter, r *http.Request, encoder *json.Encoder, placeID uint64) {
+	requestedPage := 1
+	if value := r.URL.Query().Get("page"); value != "" {
+		page, err := strconv.Atoi(value)
+		if err != nil || page <= 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			encoder.Encode(invalidPageJson{fmt.Sprintf("Invalid 'page' value: %v", value)})
+			return
+		}
+		requestedPage = page
+	}
+
+	reviews, total, err := paginator.Reviews.GetReviews(placeID, reviewsPageSize, (requestedPage-1)*reviewsPageSize)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		encoder.Encode(invalidPageJson{err.Error()})
+		log.Println(err)
+		return
+	}
+
+	response := reviewsResponse{
+		Name:    "Reviews",
+		PlaceID: placeID,
+		Total:   total,
+		Reviews: reviews,
+	}
+	if requestedPage != 1 {
+		response.PrevPage = new(int)
+		*response.PrevPage = requestedPage - 1
+	}
+	if requestedPage*reviewsPageSize < total {
+		response.NextPage = new(int)
+		*response.NextPage = requestedPage + 1
+	}
+
+	encoder.Encode(response)
+}
+
+func (paginator *Paginator) addReview(w http.ResponseWriter, r *http.Request, encoder *json.Encoder, placeID uint64) {
+	user, ok := paginator.Tokens.authenticatedUser(w, r, encoder)
+	if !ok {
+		return
+	}
+
+	var request reviewRequest
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&request); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		encoder.Encode(invalidPageJson{fmt.Sprintf("invalid review: %s", err)})
+		return
+	}
+
+	request.Text = strings.TrimSpace(request.Text)
+	if request.Rating < common.MinRating || request.Rating > common.MaxRating {
+		w.WriteHeader(http.StatusBadRequest)
+		encoder.Encode(invalidPageJson{fmt.Sprintf(`invalid "rating" value: %v, expected from %d to %d`, request.Rating, common.MinRating, common.MaxRating)})
+		return
+	}
+	if utf8.RuneCountInString(request.Text) > maxReviewLength {
+		w.WriteHeader(http.StatusBadRequest)
+		encoder.Encode(invalidPageJson{fmt.Sprintf(`"text" is longer than %d characters`, maxReviewLength)})
+		return
+	}
+
+	review := common.Review{
+		PlaceID:   placeID,
+		User:      user,
+		Rating:    request.Rating,
+		Text:      request.Text,
+		CreatedAt: time.Now().UTC(),
+	}
+	if err := paginator.Reviews.AddReview(review); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		encoder.Encode(invalidPageJson{err.Error()})
+		log.Println(err)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	encoder.Encode(review)
+}
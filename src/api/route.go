@@ -0,0 +1,279 @@
+package main
+
+import (
+	"common"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+)
+
+const (
+	defaultRouteDistance = 200
+	maxRouteDistance     = 5000
+	defaultRouteLimit    = 20
+	maxRouteLimit        = 100
+	maxRoutePoints       = 1000
+
+	// the route is covered by circles around points the distance apart, so the longest
+	// route is maxRouteSamples times the distance, whatever number of points it has
+	maxRouteSamples = 500
+
+	// candidates are fetched nearest to the start of the route first,
+	// so the ones beyond the limit are at the far end of the route
+	routeCandidatesBatch = 500
+	maxRouteCandidates   = 5000
+)
+
+type routeRequest struct {
+	Route    []common.Location `json:"route"`
+	Distance float64           `json:"distance"` // meters from the route
+	Limit    int               `json:"limit"`
+}
+
+type routePlace struct {
+	common.Place
+	AlongRouteM float64 `json:"along_route_m"`
+	DistanceM   float64 `json:"distance_m"`
+}
+
+type routeResponse struct {
+	Name         string       `json:"name"`
+	RouteLengthM float64      `json:"route_length_m"`
+	Places       []routePlace `json:"places"`
+
+	// set if there are more places around the route than maxRouteCandidates, so places
+	// at the far end of the route may be missing, a shorter route or distance helps
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// point in meters on a plane tangent to the earth near the route
+type planePoint struct {
+	x, y float64
+}
+
+func toPlane(location common.Location, latitudeCos float64) planePoint {
+	const metersPerDegree = math.Pi / 180 * common.EarthRadiusMeters
+	return planePoint{
+		x: location.Longitude * metersPerDegree * latitudeCos,
+		y: location.Latitude * metersPerDegree,
+	}
+}
+
+// segment of the route projected on a plane around its middle, which is precise enough for city distances
+type routeSegment struct {
+	from, to    planePoint
+	latitudeCos float64
+	start       float64 // meters from the start of the route
+	length      float64
+}
+
+func newRouteSegments(route []common.Location) ([]routeSegment, float64) {
+	segments := make([]routeSegment, 0, len(route)-1)
+	start := 0.0
+	for i := 1; i < len(route); i++ {
+		latitudeCos := math.Cos((route[i-1].Latitude + route[i].Latitude) / 2 * math.Pi / 180)
+		segment := routeSegment{
+			from:        toPlane(route[i-1], latitudeCos),
+			to:          toPlane(route[i], latitudeCos),
+			latitudeCos: latitudeCos,
+			start:       start,
+		}
+		segment.length = math.Hypot(segment.to.x-segment.from.x, segment.to.y-segment.from.y)
+
+		segments = append(segments, segment)
+		start += segment.length
+	}
+	return segments, start
+}
+
+// returns how far along the route the closest point to the location is and how far the location is from it
+func projectOnRoute(segments []routeSegment, location common.Location) (along, distance float64) {
+	distance = math.Inf(1)
+	for _, segment := range segments {
+		point := toPlane(location, segment.latitudeCos)
+
+		position := 0.0
+		if segment.length > 0 {
+			dx, dy := segment.to.x-segment.from.x, segment.to.y-segment.from.y
+			position = ((point.x-segment.from.x)*dx + (point.y-segment.from.y)*dy) / segment.length
+			position = math.Max(0, math.Min(segment.length, position))
+		}
+
+		closestX := segment.from.x
+		closestY := segment.from.y
+		if segment.length > 0 {
+			closestX += (segment.to.x - segment.from.x) * position / segment.length
+			closestY += (segment.to.y - segment.from.y) * position / segment.length
+		}
+
+		if offset := math.Hypot(point.x-closestX, point.y-closestY); offset < distance {
+			along, distance = segment.start+position, offset
+		}
+	}
+	return along, distance
+}
+
+// Points of the route the step apart along it, along with its end. Vertices aren't
+// sampled themselves, so the number of samples depends on the length of the route
+// only, while a point of the route is at most half the step away from a sample.
+func sampleRoute(route []common.Location, segments []routeSegment, routeLength, step float64) []common.Location {
+	samples := make([]common.Location, 0, int(routeLength/step)+2)
+	i := 0
+	for position := 0.0; position < routeLength; position += step {
+		for i < len(segments)-1 && segments[i].start+segments[i].length < position {
+			i++
+		}
+
+		fraction := 0.0
+		if segments[i].length > 0 {
+			fraction = math.Min(1, (position-segments[i].start)/segments[i].length)
+		}
+		samples = append(samples, common.Location{
+			Latitude:  route[i].Latitude + (route[i+1].Latitude-route[i].Latitude)*fraction,
+			Longitude: route[i].Longitude + (route[i+1].Longitude-route[i].Longitude)*fraction,
+		})
+	}
+	return append(samples, route[len(route)-1])
+}
+
+// Places within circles around the samples of the route, nearest to its start first. Places
+// of the corridor along the route are at most half the distance along it from a sample, so
+// circles a bit wider than the distance cover the whole corridor, places outside of it are
+// dropped after the search.
+func constructRouteRequest(samples []common.Location, distance float64, size int) sortSizeRequest {
+	radius := fmt.Sprintf("%.0fm", math.Ceil(distance*math.Sqrt(1.25)))
+
+	circles := make([]any, len(samples))
+	for i, sample := range samples {
+		circles[i] = map[string]any{"geo_distance": map[string]any{"distance": radius, "location": sample}}
+	}
+
+	request := constructGeoSortRequest(samples[0].Longitude, samples[0].Latitude, size)
+	request.Query = map[string]any{"bool": map[string]any{"filter": map[string]any{
+		"bool": map[string]any{"should": circles, "minimum_should_match": 1},
+	}}}
+
+	return withIDTiebreaker(request)
+}
+
+// Returns places within the distance from a route, in the order they are passed by.
+// Body is {"route": [{"lat": .., "lon": ..}, ..], "distance": 200, "limit": 20}.
+func (paginator *Paginator) routeApi(w http.ResponseWriter, r *http.Request) {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+
+	w.Header().Add("Content-Type", "application/json")
+
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		encoder.Encode(invalidPageJson{"not a POST method"})
+		log.Println("not a post request")
+		return
+	}
+
+	var token string
+	fmt.Sscanf(r.Header.Get("Authorization"), "Bearer %s", &token)
+
+	if _, err := paginator.Tokens.tokenUser(token); err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		encoder.Encode(invalidPageJson{"unathorized"})
+		log.Println(err)
+		return
+	}
+
+	request := routeRequest{Distance: defaultRouteDistance, Limit: defaultRouteLimit}
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&request); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		encoder.Encode(invalidPageJson{fmt.Sprintf("invalid route: %s", err)})
+		return
+	}
+
+	if len(request.Route) < 2 || len(request.Route) > maxRoutePoints {
+		w.WriteHeader(http.StatusBadRequest)
+		encoder.Encode(invalidPageJson{fmt.Sprintf(`"route" must have from 2 to %d points`, maxRoutePoints)})
+		return
+	}
+	for i, point := range request.Route {
+		if err := point.Validate(); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			encoder.Encode(invalidPageJson{fmt.Sprintf(`point %d of "route": %s`, i, err)})
+			return
+		}
+	}
+	if request.Distance <= 0 || request.Distance > maxRouteDistance {
+		w.WriteHeader(http.StatusBadRequest)
+		encoder.Encode(invalidPageJson{fmt.Sprintf(`"distance" must be above 0 and at most %d meters`, maxRouteDistance)})
+		return
+	}
+	if request.Limit <= 0 || request.Limit > maxRouteLimit {
+		w.WriteHeader(http.StatusBadRequest)
+		encoder.Encode(invalidPageJson{fmt.Sprintf(`"limit" must be from 1 to %d`, maxRouteLimit)})
+		return
+	}
+
+	segments, routeLength := newRouteSegments(request.Route)
+	samples := sampleRoute(request.Route, segments, routeLength, request.Distance)
+	if len(samples) > maxRouteSamples {
+		w.WriteHeader(http.StatusBadRequest)
+		encoder.Encode(invalidPageJson{fmt.Sprintf(
+			`route of %.0f meters is too long for "distance" of %v meters, increase the distance or split the route`,
+			routeLength,
+			request.Distance,
+		)})
+		return
+	}
+
+	// places are ordered along the route only once all of them are found
+	places := make([]routePlace, 0)
+	searchRequest := constructRouteRequest(samples, request.Distance, routeCandidatesBatch)
+	var searchAfter []any
+	truncated := false
+	for fetched := 0; ; {
+		res, err := paginator.searchSorted(searchRequest, searchAfter)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			encoder.Encode(invalidPageJson{err.Error()})
+			log.Println(err)
+			return
+		}
+
+		for _, hit := range res.Hits.Hits {
+			along, distance := projectOnRoute(segments, hit.Source.Location)
+			if distance <= request.Distance {
+				places = append(places, routePlace{
+					Place:       hit.Source,
+					AlongRouteM: math.Round(along),
+					DistanceM:   math.Round(distance),
+				})
+			}
+		}
+
+		if len(res.Hits.Hits) < routeCandidatesBatch {
+			break
+		}
+		fetched += len(res.Hits.Hits)
+		if fetched >= maxRouteCandidates {
+			truncated = true
+			log.Printf("route of %.0f meters has more than %d candidates, the rest are left out\n", routeLength, maxRouteCandidates)
+			break
+		}
+		searchAfter = res.Hits.Hits[len(res.Hits.Hits)-1].Sort
+	}
+
+	sort.SliceStable(places, func(i, j int) bool { return places[i].AlongRouteM < places[j].AlongRouteM })
+	if len(places) > request.Limit {
+		places = places[:request.Limit]
+	}
+
+	encoder.Encode(routeResponse{
+		Name:         "Route",
+		RouteLengthM: math.Round(routeLength),
+		Places:       places,
+		Truncated:    truncated,
+	})
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"common"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"paginate"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8"
+)
+
+// a degree of latitude is about 111 km, so 0.001 is about 111 m
+func TestProjectOnRoute(t *testing.T) {
+	route := []common.Location{
+		{Latitude: 55.75, Longitude: 37.6},
+		{Latitude: 55.76, Longitude: 37.6},
+		{Latitude: 55.76, Longitude: 37.62},
+	}
+	segments, length := newRouteSegments(route)
+	firstLength := segments[0].length
+
+	tests := []struct {
+		name     string
+		location common.Location
+		along    float64
+		distance float64
+	}{
+		{name: "start", location: route[0], along: 0, distance: 0},
+		{name: "end", location: route[2], along: length, distance: 0},
+		{name: "corner", location: route[1], along: firstLength, distance: 0},
+		{name: "beside the first segment", location: common.Location{Latitude: 55.755, Longitude: 37.601}, along: firstLength / 2, distance: 62.7},
+		{name: "before the start", location: common.Location{Latitude: 55.749, Longitude: 37.6}, along: 0, distance: 111.2},
+		{name: "beside the second segment", location: common.Location{Latitude: 55.761, Longitude: 37.61}, along: firstLength + segments[1].length/2, distance: 111.2},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			along, distance := projectOnRoute(segments, test.location)
+			if math.Abs(along-test.along) > 1 || math.Abs(distance-test.distance) > 1 {
+				t.Errorf("projectOnRoute = %.1f along, %.1f away, want %.1f and %.1f", along, distance, test.along, test.distance)
+			}
+		})
+	}
+}
+
+func TestSampleRoute(t *testing.T) {
+	straight := []common.Location{{Latitude: 55.75, Longitude: 37.6}, {Latitude: 55.77, Longitude: 37.6}}
+
+	// the same line through a thousand vertices
+	dense := make([]common.Location, 1000)
+	for i := range dense {
+		dense[i] = common.Location{Latitude: 55.75 + 0.02*float64(i)/float64(len(dense)-1), Longitude: 37.6}
+	}
+
+	tests := []struct {
+		name  string
+		route []common.Location
+		step  float64
+	}{
+		{name: "straight", route: straight, step: 200},
+		{name: "many vertices", route: dense, step: 200},
+		{name: "step longer than the route", route: straight, step: 5000},
+		{name: "single point", route: []common.Location{straight[0], straight[0]}, step: 200},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			segments, length := newRouteSegments(test.route)
+			samples := sampleRoute(test.route, segments, length, test.step)
+
+			if want := int(math.Ceil(length/test.step)) + 1; len(samples) > want {
+				t.Errorf("%d samples, want at most %d", len(samples), want)
+			}
+			if samples[0] != test.route[0] || samples[len(samples)-1] != test.route[len(test.route)-1] {
+				t.Errorf("samples don't start and end with the route")
+			}
+
+			// every point of the route is at most half the step from a sample
+			for along := 0.0; along <= length; along += test.step / 10 {
+				fraction := along / math.Max(length, 1)
+				point := common.Location{
+					Latitude:  test.route[0].Latitude + (test.route[len(test.route)-1].Latitude-test.route[0].Latitude)*fraction,
+					Longitude: test.route[0].Longitude,
+				}
+
+				nearest := math.Inf(1)
+				for _, sample := range samples {
+					nearest = math.Min(nearest, common.DistanceMeters(point, sample))
+				}
+				if nearest > test.step/2+1 {
+					t.Fatalf("point %.0f meters along the route is %.1f meters from the nearest sample", along, nearest)
+				}
+			}
+		})
+	}
+}
+
+// Fake elasticsearch sorting places by the sort of the request and paging them with search_after.
+// Every place is returned at the distance of "distance" km, so all of them tie on distance.
+type sortedSearch struct {
+	t        *testing.T
+	places   []common.Place
+	distance float64
+
+	mutex    sync.Mutex
+	returned map[uint64]int
+}
+
+func (search *sortedSearch) sortValues(place common.Place, sort []map[string]any) []any {
+	values := make([]any, 0, len(sort))
+	for _, entry := range sort {
+		for field := range entry {
+			switch field {
+			case "_geo_distance":
+				values = append(values, search.distance)
+			case "_score":
+				values = append(values, 1.0)
+			case "id":
+				values = append(values, float64(place.ID))
+			default:
+				search.t.Errorf("unexpected sort by %q", field)
+			}
+		}
+	}
+	return values
+}
+
+func compareSortValues(a, b []any) int {
+	for i := range a {
+		if i >= len(b) {
+			return 1
+		}
+		if a[i].(float64) != b[i].(float64) {
+			if a[i].(float64) < b[i].(float64) {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+func (search *sortedSearch) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("X-Elastic-Product", "Elasticsearch")
+	w.Header().Set("Content-Type", "application/json")
+
+	var request struct {
+		Size        int              `json:"size"`
+		Sort        []map[string]any `json:"sort"`
+		SearchAfter []any            `json:"search_after"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		search.t.Error(err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	type hit struct {
+		ID     string       `json:"_id"`
+		Source common.Place `json:"_source"`
+		Sort   []any        `json:"sort"`
+	}
+	hits := make([]hit, 0, len(search.places))
+	for _, place := range search.places {
+		hits = append(hits, hit{ID: fmt.Sprint(place.ID), Source: place, Sort: search.sortValues(place, request.Sort)})
+	}
+	sort.SliceStable(hits, func(i, j int) bool { return compareSortValues(hits[i].Sort, hits[j].Sort) < 0 })
+
+	page := make([]hit, 0, request.Size)
+	for _, hit := range hits {
+		if len(page) < request.Size && (request.SearchAfter == nil || compareSortValues(hit.Sort, request.SearchAfter) > 0) {
+			page = append(page, hit)
+		}
+	}
+
+	search.mutex.Lock()
+	for _, hit := range page {
+		search.returned[hit.Source.ID]++
+	}
+	search.mutex.Unlock()
+
+	json.NewEncoder(w).Encode(map[string]any{"hits": map[string]any{"hits": page}})
+}
+
+func newSortedSearch(t *testing.T, places []common.Place, distance float64) (*sortedSearch, *Paginator) {
+	t.Helper()
+
+	search := &sortedSearch{t: t, places: places, distance: distance, returned: make(map[uint64]int)}
+	server := httptest.NewServer(search)
+	t.Cleanup(server.Close)
+
+	client, err := elasticsearch.NewClient(elasticsearch.Config{Addresses: []string{server.URL}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	paginator := &Paginator{ElasticPaginator: paginate.ElasticPaginator{Client: client, Index: "places"}, Tokens: newTestIssuer(t)}
+
+	return search, paginator
+}
+
+// more places than a batch at the very same spot are all found, none of them twice
+func TestRouteApiPagesThroughPlacesAtTheSameDistance(t *testing.T) {
+	start := common.Location{Latitude: 55.75, Longitude: 37.6}
+	places := make([]common.Place, routeCandidatesBatch+100)
+	for i := range places {
+		places[i] = common.Place{ID: uint64(i + 1), Name: fmt.Sprintf("place %d", i+1), Location: start}
+	}
+	search, paginator := newSortedSearch(t, places, 0)
+
+	token, err := paginator.Tokens.createToken("", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := `{"route": [{"lat": 55.75, "lon": 37.6}, {"lat": 55.76, "lon": 37.6}], "distance": 200, "limit": 100}`
+	request := httptest.NewRequest(http.MethodPost, "/api/route", strings.NewReader(body))
+	request.Header.Set("Authorization", "Bearer "+token)
+	recorder := httptest.NewRecorder()
+
+	paginator.routeApi(recorder, request)
+
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", recorder.Code, recorder.Body)
+	}
+	if len(search.returned) != len(places) {
+		t.Errorf("%d of %d places are found", len(search.returned), len(places))
+	}
+	for id, count := range search.returned {
+		if count != 1 {
+			t.Errorf("place %d is found %d times", id, count)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package common
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// mean radius of the earth used by elasticsearch for arc distances
+const EarthRadiusMeters = 6371008.7714
+
+const (
+	MinLatitude  = -90.0
+	MaxLatitude  = 90.0
+	MinLongitude = -180.0
+	MaxLongitude = 180.0
+)
+
+// Invalid coordinate, Parameter names where it came from, e.g. "lat"
+// or "ll", so the error can be shown to whoever passed it.
+type CoordinateError struct {
+	Parameter string
+	Value     string
+	Min, Max  float64
+}
+
+func (err *CoordinateError) Error() string {
+	return fmt.Sprintf("invalid %q value: %q, expected a number from %v to %v", err.Parameter, err.Value, err.Min, err.Max)
+}
+
+func parseCoordinate(parameter, value string, min, max float64) (float64, error) {
+	coordinate, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil || !isCoordinateInRange(coordinate, min, max) {
+		return 0, &CoordinateError{Parameter: parameter, Value: value, Min: min, Max: max}
+	}
+	return coordinate, nil
+}
+
+// NaN and infinities are never in range
+func isCoordinateInRange(coordinate, min, max float64) bool {
+	return coordinate >= min && coordinate <= max
+}
+
+func ParseLatitude(parameter, value string) (float64, error) {
+	return parseCoordinate(parameter, value, MinLatitude, MaxLatitude)
+}
+
+func ParseLongitude(parameter, value string) (float64, error) {
+	return parseCoordinate(parameter, value, MinLongitude, MaxLongitude)
+}
+
+// checks the location as if it came from "lat" and "lon" parameters
+func (location Location) Validate() error {
+	if !isCoordinateInRange(location.Latitude, MinLatitude, MaxLatitude) {
+		return &CoordinateError{Parameter: "lat", Value: fmt.Sprint(location.Latitude), Min: MinLatitude, Max: MaxLatitude}
+	}
+	if !isCoordinateInRange(location.Longitude, MinLongitude, MaxLongitude) {
+		return &CoordinateError{Parameter: "lon", Value: fmt.Sprint(location.Longitude), Min: MinLongitude, Max: MaxLongitude}
+	}
+	return nil
+}
+
+// parses "55.7558,37.6173", latitude first
+func ParseLatLon(parameter, value string) (Location, error) {
+	latitude, longitude, found := strings.Cut(value, ",")
+	if !found {
+		return Location{}, fmt.Errorf("invalid %q value: %q, expected \"lat,lon\", e.g. \"55.7558,37.6173\"", parameter, value)
+	}
+
+	var location Location
+	var err error
+	if location.Latitude, err = ParseLatitude(parameter+" latitude", latitude); err != nil {
+		return Location{}, err
+	}
+	if location.Longitude, err = ParseLongitude(parameter+" longitude", longitude); err != nil {
+		return Location{}, err
+	}
+	return location, nil
+}
+
+const geohashAlphabet = "0123456789bcdefghjkmnpqrstuvwxyz"
+
+// maximum length of geohashes elasticsearch accepts
+const maxGeohashLength = 12
+
+// decodes the center of the geohash cell, e.g. "ucftpuzx" near the Kremlin
+func ParseGeohash(parameter, value string) (Location, error) {
+	hash := strings.ToLower(strings.TrimSpace(value))
+	if hash == "" || len(hash) > maxGeohashLength {
+		return Location{}, fmt.Errorf("invalid %q value: %q, expected a geohash of 1 to %d characters", parameter, value, maxGeohashLength)
+	}
+
+	latitude := [2]float64{MinLatitude, MaxLatitude}
+	longitude := [2]float64{MinLongitude, MaxLongitude}
+	isLongitude := true
+	for _, character := range hash {
+		index := strings.IndexRune(geohashAlphabet, character)
+		if index < 0 {
+			return Location{}, fmt.Errorf("invalid %q value: %q, %q is not a geohash character", parameter, value, character)
+		}
+
+		// bits alternate between longitude and latitude, starting with longitude
+		for bit := 4; bit >= 0; bit-- {
+			interval := &latitude
+			if isLongitude {
+				interval = &longitude
+			}
+
+			middle := (interval[0] + interval[1]) / 2
+			if index&(1<<bit) != 0 {
+				interval[0] = middle
+			} else {
+				interval[1] = middle
+			}
+			isLongitude = !isLongitude
+		}
+	}
+
+	return Location{
+		Latitude:  (latitude[0] + latitude[1]) / 2,
+		Longitude: (longitude[0] + longitude[1]) / 2,
+	}, nil
+}
+
+// great-circle distance between two points in meters
+func DistanceMeters(from, to Location) float64 {
+	fromLat, toLat := from.Latitude*math.Pi/180, to.Latitude*math.Pi/180
+	deltaLat := toLat - fromLat
+	deltaLon := (to.Longitude - from.Longitude) * math.Pi / 180
+
+	a := math.Sin(deltaLat/2)*math.Sin(deltaLat/2) +
+		math.Cos(fromLat)*math.Cos(toLat)*math.Sin(deltaLon/2)*math.Sin(deltaLon/2)
+	return 2 * EarthRadiusMeters * math.Asin(math.Min(1, math.Sqrt(a)))
+}
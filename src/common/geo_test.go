@@ -0,0 +1,95 @@
+package common
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestParseGeohash(t *testing.T) {
+	tests := []struct {
+		value     string
+		latitude  float64
+		longitude float64
+		tolerance float64 // half of the cell, in degrees
+		wantErr   bool
+	}{
+		// the example of the original geohash description
+		{value: "ezs42", latitude: 42.605, longitude: -5.603, tolerance: 0.03},
+		{value: "ucftpuzx", latitude: 55.7500, longitude: 37.6167, tolerance: 0.0002},
+		{value: " UCFTPUZX ", latitude: 55.7500, longitude: 37.6167, tolerance: 0.0002},
+		{value: "u", latitude: 67.5, longitude: 22.5, tolerance: 1e-9},
+		{value: "s0000000000", latitude: 0, longitude: 0, tolerance: 1e-5},
+		{value: "", wantErr: true},
+		{value: "ucftpuzxucftp", wantErr: true},
+		{value: "ucfa", wantErr: true}, // "a" is left out of the alphabet
+		{value: "ucf tp", wantErr: true},
+	}
+
+	for _, test := range tests {
+		location, err := ParseGeohash("geohash", test.value)
+		if (err != nil) != test.wantErr {
+			t.Errorf("ParseGeohash(%q) error = %v, want error %v", test.value, err, test.wantErr)
+			continue
+		}
+		if test.wantErr {
+			continue
+		}
+
+		if math.Abs(location.Latitude-test.latitude) > test.tolerance || math.Abs(location.Longitude-test.longitude) > test.tolerance {
+			t.Errorf("ParseGeohash(%q) = %v, want lat %v lon %v", test.value, location, test.latitude, test.longitude)
+		}
+	}
+}
+
+func TestParseLatLon(t *testing.T) {
+	tests := []struct {
+		value     string
+		want      Location
+		parameter string // of the coordinate error, empty if the value is valid or not a pair
+		wantErr   bool
+	}{
+		{value: "55.7558,37.6173", want: Location{Latitude: 55.7558, Longitude: 37.6173}},
+		{value: " -33.9 , 151.2 ", want: Location{Latitude: -33.9, Longitude: 151.2}},
+		{value: "90,-180", want: Location{Latitude: 90, Longitude: -180}},
+		{value: "55.7558", wantErr: true},
+		{value: "91,37", parameter: "ll latitude", wantErr: true},
+		{value: "55,181", parameter: "ll longitude", wantErr: true},
+		{value: "NaN,37", parameter: "ll latitude", wantErr: true},
+		{value: "55,Inf", parameter: "ll longitude", wantErr: true},
+	}
+
+	for _, test := range tests {
+		location, err := ParseLatLon("ll", test.value)
+		if (err != nil) != test.wantErr {
+			t.Errorf("ParseLatLon(%q) error = %v, want error %v", test.value, err, test.wantErr)
+			continue
+		}
+		if !test.wantErr && location != test.want {
+			t.Errorf("ParseLatLon(%q) = %v, want %v", test.value, location, test.want)
+		}
+
+		var coordinateErr *CoordinateError
+		if test.parameter != "" && (!errors.As(err, &coordinateErr) || coordinateErr.Parameter != test.parameter) {
+			t.Errorf("ParseLatLon(%q) error = %v, want coordinate error of %q", test.value, err, test.parameter)
+		}
+	}
+}
+
+func TestDistanceMeters(t *testing.T) {
+	tests := []struct {
+		from, to Location
+		want     float64
+	}{
+		{from: Location{Latitude: 55.75, Longitude: 37.6}, to: Location{Latitude: 55.75, Longitude: 37.6}, want: 0},
+		{from: Location{Latitude: 0, Longitude: 0}, to: Location{Latitude: 1, Longitude: 0}, want: 111_195},
+		{from: Location{Latitude: 0, Longitude: 179.5}, to: Location{Latitude: 0, Longitude: -179.5}, want: 111_195},
+		{from: Location{Latitude: 90, Longitude: 0}, to: Location{Latitude: -90, Longitude: 0}, want: math.Pi * EarthRadiusMeters},
+	}
+
+	for _, test := range tests {
+		if got := DistanceMeters(test.from, test.to); math.Abs(got-test.want) > 1 {
+			t.Errorf("DistanceMeters(%v, %v) = %.1f, want %.1f", test.from, test.to, got, test.want)
+		}
+	}
+}
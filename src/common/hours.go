@@ -0,0 +1,97 @@
+package common
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// opening hours of places are local times of this timezone
+const PlacesTimezone = "Europe/Moscow"
+
+// minutes since midnight of "15:04", -1 if the time is invalid
+func clockMinutes(value string) int {
+	hours, minutes, found := strings.Cut(value, ":")
+	if !found {
+		return -1
+	}
+
+	h, err := strconv.Atoi(hours)
+	if err != nil {
+		return -1
+	}
+	m, err := strconv.Atoi(minutes)
+	if err != nil {
+		return -1
+	}
+
+	if h < 0 || h > 24 || m < 0 || m > 59 || (h == 24 && m != 0) {
+		return -1
+	}
+	return h*60 + m
+}
+
+type timeRange struct {
+	open, close int
+}
+
+// closing time not later than opening time means the range ends on the next day
+func (r timeRange) isOvernight() bool {
+	return r.close <= r.open
+}
+
+// ranges of the date, exceptions of the date take precedence over weekly hours
+func (hours OpeningHours) rangesOn(date time.Time) []timeRange {
+	ranges := make([]timeRange, 0, 2)
+
+	day := date.Format(time.DateOnly)
+	hasExceptions := false
+	for _, exception := range hours.Exceptions {
+		if exception.Date != day {
+			continue
+		}
+		hasExceptions = true
+
+		if exception.Closed {
+			return nil
+		}
+		ranges = append(ranges, timeRange{clockMinutes(exception.Open), clockMinutes(exception.Close)})
+	}
+	if hasExceptions {
+		return ranges
+	}
+
+	for _, interval := range hours.Weekly {
+		if interval.Day == date.Weekday() {
+			ranges = append(ranges, timeRange{clockMinutes(interval.Open), clockMinutes(interval.Close)})
+		}
+	}
+	return ranges
+}
+
+// Reports whether the place is open at the moment, which must be in the timezone
+// of the place. Ranges past midnight of the previous day are taken into account.
+func (hours OpeningHours) IsOpenAt(moment time.Time) bool {
+	minute := moment.Hour()*60 + moment.Minute()
+
+	for _, r := range hours.rangesOn(moment) {
+		if r.open < 0 || r.close < 0 {
+			continue
+		}
+		if minute >= r.open && (r.isOvernight() || minute < r.close) {
+			return true
+		}
+	}
+
+	previousDay := moment.AddDate(0, 0, -1)
+	for _, r := range hours.rangesOn(previousDay) {
+		if r.open < 0 || r.close < 0 {
+			continue
+		}
+		if r.isOvernight() && minute < r.close {
+			return true
+		}
+	}
+
+	return false
+}
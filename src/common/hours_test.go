@@ -0,0 +1,80 @@
+package common
+
+import (
+	"testing"
+	"time"
+)
+
+func TestIsOpenAt(t *testing.T) {
+	location, err := time.LoadLocation(PlacesTimezone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 2026-10-16 is a Friday
+	at := func(value string) time.Time {
+		moment, err := time.ParseInLocation("2006-01-02 15:04", value, location)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return moment
+	}
+
+	daytime := OpeningHours{Weekly: []OpeningInterval{
+		{Day: time.Friday, Open: "10:00", Close: "14:00"},
+		{Day: time.Friday, Open: "16:00", Close: "22:00"},
+	}}
+	overnight := OpeningHours{
+		Weekly: []OpeningInterval{
+			{Day: time.Friday, Open: "20:00", Close: "04:00"},
+			{Day: time.Saturday, Open: "20:00", Close: "02:00"},
+		},
+		Exceptions: []OpeningException{
+			{Date: "2026-10-24", Closed: true},
+			{Date: "2026-10-31", Open: "12:00", Close: "15:00"},
+			{Date: "2026-10-31", Open: "23:00", Close: "01:00"},
+		},
+	}
+	roundTheClock := OpeningHours{Weekly: []OpeningInterval{
+		{Day: time.Friday, Open: "00:00", Close: "24:00"},
+	}}
+
+	tests := []struct {
+		name   string
+		hours  OpeningHours
+		moment string
+		want   bool
+	}{
+		{name: "before opening", hours: daytime, moment: "2026-10-16 09:59", want: false},
+		{name: "at opening", hours: daytime, moment: "2026-10-16 10:00", want: true},
+		{name: "lunch break", hours: daytime, moment: "2026-10-16 15:00", want: false},
+		{name: "second range", hours: daytime, moment: "2026-10-16 21:59", want: true},
+		{name: "at closing", hours: daytime, moment: "2026-10-16 22:00", want: false},
+		{name: "other weekday", hours: daytime, moment: "2026-10-17 12:00", want: false},
+
+		{name: "overnight evening", hours: overnight, moment: "2026-10-16 23:30", want: true},
+		{name: "overnight after midnight", hours: overnight, moment: "2026-10-17 03:59", want: true},
+		{name: "overnight closed in the morning", hours: overnight, moment: "2026-10-17 04:00", want: false},
+		{name: "overnight of saturday ends earlier", hours: overnight, moment: "2026-10-18 03:00", want: false},
+		{name: "overnight of saturday into sunday", hours: overnight, moment: "2026-10-18 01:00", want: true},
+
+		{name: "closed exception", hours: overnight, moment: "2026-10-24 21:00", want: false},
+		{name: "closed exception stops the night", hours: overnight, moment: "2026-10-25 01:00", want: false},
+		{name: "night before a closed date", hours: overnight, moment: "2026-10-24 01:00", want: true},
+		{name: "exception replaces weekly hours", hours: overnight, moment: "2026-10-31 13:00", want: true},
+		{name: "weekly hours of an exception date", hours: overnight, moment: "2026-10-31 21:00", want: false},
+		{name: "overnight exception", hours: overnight, moment: "2026-11-01 00:30", want: true},
+
+		{name: "round the clock", hours: roundTheClock, moment: "2026-10-16 23:59", want: true},
+		{name: "round the clock ends at midnight", hours: roundTheClock, moment: "2026-10-17 00:00", want: false},
+		{name: "invalid time", hours: OpeningHours{Weekly: []OpeningInterval{{Day: time.Friday, Open: "25:00", Close: "26:00"}}}, moment: "2026-10-16 12:00", want: false},
+		{name: "no hours", hours: OpeningHours{}, moment: "2026-10-16 12:00", want: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.hours.IsOpenAt(at(test.moment)); got != test.want {
+				t.Errorf("IsOpenAt(%s) = %v, want %v", test.moment, got, test.want)
+			}
+		})
+	}
+}
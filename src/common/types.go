@@ -1,19 +1,123 @@
 package common
 
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
 type Pair[T1 any, T2 any] struct {
 	First  T1
 	Second T2
 }
 
+// Fields after Location are optional, so documents indexed
+// before they were introduced still decode.
 type Place struct {
-	ID       uint64   `json:"id"`
-	Name     string   `json:"name"`
-	Address  string   `json:"address"`
-	Phone    string   `json:"phone"`
-	Location Location `json:"location"`
+	ID           uint64        `json:"id"`
+	Name         string        `json:"name"`
+	Address      string        `json:"address"`
+	Phone        string        `json:"phone"`
+	Location     Location      `json:"location"`
+	District     string        `json:"district,omitempty"`
+	Cuisine      []string      `json:"cuisine,omitempty"`
+	OpeningHours *OpeningHours `json:"opening_hours,omitempty"`
+	PriceLevel   int           `json:"price_level,omitempty"` // from 1 to 4, 0 if unknown
+	Website      string        `json:"website,omitempty"`
+	CreatedAt    *time.Time    `json:"created_at,omitempty"`
+	UpdatedAt    *time.Time    `json:"updated_at,omitempty"`
+
+	// kept up to date with reviews of the place
+	RatingAverage float64 `json:"rating_average,omitempty"`
+	RatingCount   int     `json:"rating_count,omitempty"`
+
+	// duplicates merged into the place by the dedup command of the inserter
+	MergedIDs []uint64 `json:"merged_ids,omitempty"`
 }
 
 type Location struct {
 	Latitude  float64 `json:"lat"`
 	Longitude float64 `json:"lon"`
 }
+
+const MaxPriceLevel = 4
+
+const (
+	MinRating = 1
+	MaxRating = 5
+)
+
+// every user has at most one review of a place, a new one replaces the previous
+type Review struct {
+	PlaceID   uint64    `json:"place_id"`
+	User      string    `json:"user"`
+	Rating    int       `json:"rating"`
+	Text      string    `json:"text"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type OpeningHours struct {
+	Weekly     []OpeningInterval  `json:"weekly"`
+	Exceptions []OpeningException `json:"exceptions,omitempty"` // replace weekly hours on their dates
+}
+
+// Open and Close are "15:04" local times, Close not later
+// than Open means the place closes after midnight.
+type OpeningInterval struct {
+	Day   time.Weekday `json:"day"`
+	Open  string       `json:"open"`
+	Close string       `json:"close"`
+}
+
+// hours of a particular date, e.g. a holiday; several exceptions of the same date
+// add up, while a closed one means the place doesn't open that day at all
+type OpeningException struct {
+	Date   string `json:"date"` // "2006-01-02"
+	Open   string `json:"open,omitempty"`
+	Close  string `json:"close,omitempty"`
+	Closed bool   `json:"closed,omitempty"`
+}
+
+// named list of places of a user, favorites are a list as well
+type PlaceList struct {
+	ID        string    `json:"id"`
+	User      string    `json:"user"`
+	Name      string    `json:"name"`
+	PlaceIDs  []uint64  `json:"place_ids"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// id of the list holding favorites of a user
+const FavoritesListID = "favorites"
+
+var weekdayAbbreviations = []string{"Su", "Mo", "Tu", "We", "Th", "Fr", "Sa"}
+
+func WeekdayAbbreviation(day time.Weekday) string {
+	return weekdayAbbreviations[day]
+}
+
+func ParseWeekday(abbreviation string) (time.Weekday, error) {
+	for day, known := range weekdayAbbreviations {
+		if strings.EqualFold(abbreviation, known) {
+			return time.Weekday(day), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown day %q, expected one of %q", abbreviation, weekdayAbbreviations)
+}
+
+// e.g. "Mo 09:00-22:00; Tu 09:00-22:00"
+func (hours OpeningHours) String() string {
+	intervals := make([]string, len(hours.Weekly))
+	for i, interval := range hours.Weekly {
+		intervals[i] = fmt.Sprintf("%s %s-%s", WeekdayAbbreviation(interval.Day), interval.Open, interval.Close)
+	}
+	for _, exception := range hours.Exceptions {
+		if exception.Closed {
+			intervals = append(intervals, fmt.Sprintf("%s off", exception.Date))
+		} else {
+			intervals = append(intervals, fmt.Sprintf("%s %s-%s", exception.Date, exception.Open, exception.Close))
+		}
+	}
+	return strings.Join(intervals, "; ")
+}
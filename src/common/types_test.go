@@ -0,0 +1,90 @@
+package common
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPlaceDecoding(t *testing.T) {
+	created := time.Date(2023, 9, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		document string
+		want     Place
+	}{
+		{
+			// documents indexed before details were introduced
+			name:     "old document",
+			document: `{"id": 1, "name": "Cafe", "address": "Tverskaya 1", "phone": "(495) 676-55-35", "location": {"lat": 55.7, "lon": 37.6}}`,
+			want: Place{
+				ID: 1, Name: "Cafe", Address: "Tverskaya 1", Phone: "(495) 676-55-35",
+				Location: Location{Latitude: 55.7, Longitude: 37.6},
+			},
+		},
+		{
+			name: "details",
+			document: `{"id": 2, "name": "Bar", "address": "", "phone": "", "location": {"lat": 55.7, "lon": 37.6},
+				"district": "Tverskoy", "cuisine": ["italian"], "price_level": 3, "website": "https://bar.example",
+				"opening_hours": {"weekly": [{"day": 5, "open": "18:00", "close": "02:00"}]},
+				"created_at": "2023-09-01T00:00:00Z"}`,
+			want: Place{
+				ID: 2, Name: "Bar", Location: Location{Latitude: 55.7, Longitude: 37.6},
+				District:     "Tverskoy",
+				Cuisine:      []string{"italian"},
+				OpeningHours: &OpeningHours{Weekly: []OpeningInterval{{Day: time.Friday, Open: "18:00", Close: "02:00"}}},
+				PriceLevel:   3,
+				Website:      "https://bar.example",
+				CreatedAt:    &created,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var place Place
+			if err := json.Unmarshal([]byte(test.document), &place); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(place, test.want) {
+				t.Errorf("decoded %+v, want %+v", place, test.want)
+			}
+		})
+	}
+}
+
+// unset details are left out, so places without them are stored as before
+func TestPlaceEncodingOmitsUnsetDetails(t *testing.T) {
+	marshalized, err := json.Marshal(Place{ID: 1, Name: "Cafe", Location: Location{Latitude: 55.7, Longitude: 37.6}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `{"id":1,"name":"Cafe","address":"","phone":"","location":{"lat":55.7,"lon":37.6}}`
+	if string(marshalized) != want {
+		t.Errorf("encoded %s, want %s", marshalized, want)
+	}
+}
+
+func TestParseWeekday(t *testing.T) {
+	tests := []struct {
+		abbreviation string
+		want         time.Weekday
+		wantErr      bool
+	}{
+		{abbreviation: "Su", want: time.Sunday},
+		{abbreviation: "mo", want: time.Monday},
+		{abbreviation: "SA", want: time.Saturday},
+		{abbreviation: "Mon", wantErr: true},
+		{abbreviation: "", wantErr: true},
+	}
+
+	for _, test := range tests {
+		got, err := ParseWeekday(test.abbreviation)
+		if got != test.want || (err != nil) != test.wantErr {
+			t.Errorf("ParseWeekday(%q) = %v, %v, want %v and error %v", test.abbreviation, got, err, test.want, test.wantErr)
+		}
+	}
+}
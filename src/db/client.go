@@ -55,12 +55,19 @@ func getCredentials() map[string]string {
 	return result
 }
 
-func CreateClient(CACert []byte) (*elasticsearch.Client, error) {
+// options are applied to the config after credentials are filled in
+func CreateClient(CACert []byte, options ...func(*elasticsearch.Config)) (*elasticsearch.Client, error) {
 	credentials := getCredentials()
-	return elasticsearch.NewClient(elasticsearch.Config{
+	config := elasticsearch.Config{
 		Password:  credentials[elasticPassword],
 		Username:  credentials[elasticUser],
 		Addresses: []string{credentials[elasticUrl]},
 		CACert:    CACert,
-	})
+	}
+
+	for _, option := range options {
+		option(&config)
+	}
+
+	return elasticsearch.NewClient(config)
 }
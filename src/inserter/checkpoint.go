@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// state of an interrupted import, enough to continue it with --resume
+type checkpoint struct {
+	Data      string    `json:"data"`
+	Mode      string    `json:"mode"`
+	Index     string    `json:"index"`
+	Records   uint64    `json:"records"`  // number of leading records elasticsearch is done with
+	Line      int       `json:"line"`     // line of the last of those records
+	Inserted  uint64    `json:"inserted"` // how many of them were indexed
+	Batches   uint64    `json:"batches"`  // number of acknowledged bulk requests
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func loadCheckpoint(path string) (*checkpoint, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var loaded checkpoint
+	if err := json.Unmarshal(content, &loaded); err != nil {
+		return nil, fmt.Errorf("invalid checkpoint \"%s\": %w", path, err)
+	}
+
+	return &loaded, nil
+}
+
+type finishedRecord struct {
+	line     int
+	inserted bool
+}
+
+// Tracks records which are done with, either acknowledged by elasticsearch
+// or rejected. Records are acknowledged out of order, so the checkpoint
+// moves only past the ones with all preceding records done as well.
+type checkpointTracker struct {
+	mutex    sync.Mutex
+	path     string
+	state    checkpoint
+	finished map[uint64]finishedRecord // finished records past the checkpoint
+}
+
+// sequence numbers of records start from 1, previous is the checkpoint to continue from
+func newCheckpointTracker(path string, previous checkpoint) *checkpointTracker {
+	return &checkpointTracker{
+		path:     path,
+		state:    previous,
+		finished: make(map[uint64]finishedRecord),
+	}
+}
+
+// safe to call on nil tracker, e.g. during a dry run
+func (tracker *checkpointTracker) finish(sequence uint64, line int, inserted bool) {
+	if tracker == nil {
+		return
+	}
+
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+
+	tracker.finished[sequence] = finishedRecord{line: line, inserted: inserted}
+	for {
+		next, exists := tracker.finished[tracker.state.Records+1]
+		if !exists {
+			break
+		}
+		delete(tracker.finished, tracker.state.Records+1)
+
+		tracker.state.Records++
+		tracker.state.Line = next.line
+		if next.inserted {
+			tracker.state.Inserted++
+		}
+	}
+}
+
+// called after every flushed bulk request
+func (tracker *checkpointTracker) save() error {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+
+	tracker.state.Batches++
+	tracker.state.UpdatedAt = time.Now()
+
+	content, err := json.MarshalIndent(tracker.state, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// rename keeps the previous checkpoint intact if writing is interrupted
+	temporary := tracker.path + ".tmp"
+	if err := os.WriteFile(temporary, content, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(temporary, tracker.path)
+}
+
+// the import is over, so there is nothing to resume
+func (tracker *checkpointTracker) remove() error {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+
+	if err := os.Remove(tracker.path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckpointTrackerFinish(t *testing.T) {
+	type finished struct {
+		sequence uint64
+		line     int
+		inserted bool
+	}
+
+	tests := []struct {
+		name     string
+		previous checkpoint
+		finished []finished
+		records  uint64
+		line     int
+		inserted uint64
+	}{
+		{
+			name:     "in order",
+			finished: []finished{{1, 2, true}, {2, 3, false}, {3, 4, true}},
+			records:  3, line: 4, inserted: 2,
+		},
+		{
+			// the checkpoint can't move past the second record until it's done
+			name:     "gap",
+			finished: []finished{{1, 2, true}, {3, 4, true}, {4, 5, true}},
+			records:  1, line: 2, inserted: 1,
+		},
+		{
+			name:     "gap filled",
+			finished: []finished{{3, 4, true}, {1, 2, true}, {4, 6, false}, {2, 3, true}},
+			records:  4, line: 6, inserted: 3,
+		},
+		{
+			name:     "resumed",
+			previous: checkpoint{Records: 10, Line: 12, Inserted: 9},
+			finished: []finished{{12, 14, true}, {11, 13, true}},
+			records:  12, line: 14, inserted: 11,
+		},
+		{
+			name:     "nothing finished",
+			previous: checkpoint{Records: 10, Line: 12, Inserted: 9},
+			records:  10, line: 12, inserted: 9,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tracker := newCheckpointTracker(filepath.Join(t.TempDir(), "checkpoint.json"), test.previous)
+			for _, record := range test.finished {
+				tracker.finish(record.sequence, record.line, record.inserted)
+			}
+
+			state := tracker.state
+			if state.Records != test.records || state.Line != test.line || state.Inserted != test.inserted {
+				t.Errorf(
+					"checkpoint at %d records, line %d, %d inserted, want %d, %d and %d",
+					state.Records, state.Line, state.Inserted, test.records, test.line, test.inserted,
+				)
+			}
+		})
+	}
+}
+
+func TestCheckpointTrackerSaveAndRemove(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "checkpoint.json")
+	tracker := newCheckpointTracker(path, checkpoint{Data: "data.csv", Mode: modeReplace, Index: "places-20261019T153012"})
+	tracker.finish(1, 2, true)
+	tracker.finish(2, 3, false)
+
+	for i := 0; i < 2; i++ {
+		if err := tracker.save(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	loaded, err := loadCheckpoint(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Data != "data.csv" || loaded.Index != "places-20261019T153012" || loaded.Records != 2 || loaded.Line != 3 || loaded.Inserted != 1 || loaded.Batches != 2 {
+		t.Errorf("loaded checkpoint %+v doesn't match the saved one", loaded)
+	}
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("temporary file is left behind: %v", err)
+	}
+
+	if err := tracker.remove(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("checkpoint is not removed: %v", err)
+	}
+	// the import may end before anything was saved
+	if err := tracker.remove(); err != nil {
+		t.Errorf("removing a missing checkpoint: %s", err)
+	}
+
+	var nilTracker *checkpointTracker
+	nilTracker.finish(1, 2, true)
+}
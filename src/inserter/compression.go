@@ -0,0 +1,172 @@
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const stdinPath = "-"
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	zipMagic  = []byte{0x50, 0x4b, 0x03, 0x04}
+)
+
+// counts bytes read from the raw data file to report progress
+type countingReader struct {
+	reader io.Reader
+	count  uint64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	atomic.AddUint64(&r.count, uint64(n))
+	return n, err
+}
+
+func (r *countingReader) readBytes() uint64 {
+	return atomic.LoadUint64(&r.count)
+}
+
+// decompressed contents of the data file
+type dataInput struct {
+	io.Reader
+	closers []io.Closer
+	name    string          // name without compression extensions to detect the format by
+	size    int64           // size of the raw file, 0 if unknown
+	raw     *countingReader // reads the raw file
+}
+
+// closes both the decompressor and the underlying file
+func (input *dataInput) Close() error {
+	var firstErr error
+	for _, closer := range input.closers {
+		if err := closer.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// Opens the data file, "-" stands for stdin. Compression is detected
+// by magic bytes rather than by extension. For zip archives the name
+// of the input is the name of the archived file.
+func openData(path string) (*dataInput, error) {
+	var file *os.File
+	if path == stdinPath {
+		file = os.Stdin
+	} else {
+		var err error
+		if file, err = os.Open(path); err != nil {
+			return nil, err
+		}
+	}
+
+	input := &dataInput{
+		closers: []io.Closer{file},
+		name:    strings.TrimSuffix(strings.TrimSuffix(path, ".gz"), ".zst"),
+		raw:     &countingReader{reader: file},
+	}
+	if info, err := file.Stat(); err == nil && info.Mode().IsRegular() {
+		input.size = info.Size()
+	}
+
+	buffered := bufio.NewReader(input.raw)
+	magic, _ := buffered.Peek(4)
+
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		decompressor, err := gzip.NewReader(buffered)
+		if err != nil {
+			input.Close()
+			return nil, err
+		}
+		input.Reader = decompressor
+		input.closers = append([]io.Closer{decompressor}, input.closers...)
+
+	case bytes.HasPrefix(magic, zstdMagic):
+		decompressor, err := zstd.NewReader(buffered)
+		if err != nil {
+			input.Close()
+			return nil, err
+		}
+		input.Reader = decompressor
+		input.closers = append([]io.Closer{decompressor.IOReadCloser()}, input.closers...)
+
+	case bytes.HasPrefix(magic, zipMagic):
+		if err := openZip(input, file, buffered); err != nil {
+			input.Close()
+			return nil, err
+		}
+
+	default:
+		input.Reader = buffered
+	}
+
+	return input, nil
+}
+
+// zip needs random access, so stdin is spooled to a temporary file first
+func openZip(input *dataInput, file *os.File, buffered *bufio.Reader) error {
+	archive := file
+	if file == os.Stdin {
+		spool, err := os.CreateTemp("", "inserter-*.zip")
+		if err != nil {
+			return err
+		}
+		os.Remove(spool.Name())
+		input.closers = append(input.closers, spool)
+
+		if _, err := io.Copy(spool, buffered); err != nil {
+			return err
+		}
+		archive = spool
+	}
+
+	info, err := archive.Stat()
+	if err != nil {
+		return err
+	}
+
+	zipReader, err := zip.NewReader(archive, info.Size())
+	if err != nil {
+		return err
+	}
+
+	files := make([]*zip.File, 0, len(zipReader.File))
+	for _, archived := range zipReader.File {
+		if !archived.FileInfo().IsDir() {
+			files = append(files, archived)
+		}
+	}
+	if len(files) != 1 {
+		return fmt.Errorf("zip archive must contain exactly one file, got %d", len(files))
+	}
+
+	entry, err := files[0].Open()
+	if err != nil {
+		return err
+	}
+	log.Printf("Reading \"%s\" from zip archive\n", files[0].Name)
+
+	// the archive is read at random, so progress is tracked on the decompressed entry
+	input.raw = &countingReader{reader: entry}
+	input.size = int64(files[0].UncompressedSize64)
+	input.Reader = input.raw
+	input.name = filepath.Base(files[0].Name)
+	input.closers = append([]io.Closer{entry}, input.closers...)
+
+	return nil
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const compressedData = "\tName\tAddress\tPhone\tLongitude\tLatitude\n0\tCafe\tTverskaya 1\t\t37.61\t55.75\n"
+
+func gzipData(t *testing.T, data string) []byte {
+	var compressed bytes.Buffer
+	writer := gzip.NewWriter(&compressed)
+	if _, err := writer.Write([]byte(data)); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return compressed.Bytes()
+}
+
+func zstdData(t *testing.T, data string) []byte {
+	encoder, err := zstd.NewWriter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer encoder.Close()
+	return encoder.EncodeAll([]byte(data), nil)
+}
+
+// archive of the files, directories are the names ending with a slash
+func zipData(t *testing.T, files ...string) []byte {
+	var archive bytes.Buffer
+	writer := zip.NewWriter(&archive)
+	for _, name := range files {
+		entry, err := writer.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasSuffix(name, "/") {
+			entry.Write([]byte(compressedData))
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return archive.Bytes()
+}
+
+func TestOpenData(t *testing.T) {
+	tests := []struct {
+		name     string
+		file     string
+		contents func(t *testing.T) []byte
+		input    string // name of the input to detect the format by
+		err      string
+	}{
+		{
+			name:     "plain",
+			file:     "places.csv",
+			contents: func(t *testing.T) []byte { return []byte(compressedData) },
+			input:    "places.csv",
+		},
+		{
+			name:     "gzip",
+			file:     "places.csv.gz",
+			contents: func(t *testing.T) []byte { return gzipData(t, compressedData) },
+			input:    "places.csv",
+		},
+		{
+			name:     "zstd",
+			file:     "places.tsv.zst",
+			contents: func(t *testing.T) []byte { return zstdData(t, compressedData) },
+			input:    "places.tsv",
+		},
+		{
+			// compression is detected by magic bytes, whatever the extension
+			name:     "gzip without extension",
+			file:     "places.csv",
+			contents: func(t *testing.T) []byte { return gzipData(t, compressedData) },
+			input:    "places.csv",
+		},
+		{
+			name:     "zip",
+			file:     "export.zip",
+			contents: func(t *testing.T) []byte { return zipData(t, "export/", "export/places.tsv") },
+			input:    "places.tsv",
+		},
+		{
+			name:     "zip of several files",
+			file:     "export.zip",
+			contents: func(t *testing.T) []byte { return zipData(t, "places.csv", "readme.txt") },
+			err:      "zip archive must contain exactly one file, got 2",
+		},
+		{
+			name:     "empty zip",
+			file:     "export.zip",
+			contents: func(t *testing.T) []byte { return zipData(t, "export/") },
+			err:      "zip archive must contain exactly one file, got 0",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), test.file)
+			if err := os.WriteFile(path, test.contents(t), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			input, err := openData(path)
+			if test.err != "" {
+				if err == nil || err.Error() != test.err {
+					t.Fatalf("openData returned %v, want %q", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer input.Close()
+
+			data, err := io.ReadAll(input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != compressedData {
+				t.Errorf("read %q, want %q", data, compressedData)
+			}
+
+			if filepath.Base(input.name) != test.input {
+				t.Errorf("input name %q, want %q", input.name, test.input)
+			}
+
+			// progress reaches the whole size once everything is read
+			if input.size == 0 || input.raw.readBytes() != uint64(input.size) {
+				t.Errorf("read %d bytes of %d", input.raw.readBytes(), input.size)
+			}
+		})
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+
+	"github.com/elastic/go-elasticsearch/v8/esutil"
+)
+
+// Rejected records are written along with the header of the data file
+// and two trailing columns, so the dead-letter file can be fed back
+// to the inserter as a data file once the cause of failures is fixed.
+type deadLetter struct {
+	mutex  sync.Mutex
+	path   string
+	header []string
+	file   *os.File
+	writer *csv.Writer
+	count  uint64
+
+	// records are appended to the file of the resumed import
+	appendRecords bool
+
+	// reasons are kept in memory during a dry run to be reported at the end
+	collect bool
+	reasons []string
+}
+
+func newDeadLetter(path string) *deadLetter {
+	return &deadLetter{path: path}
+}
+
+func (letter *deadLetter) setHeader(header []string) {
+	letter.mutex.Lock()
+	defer letter.mutex.Unlock()
+
+	letter.header = header
+}
+
+// the file is created on the first rejected record
+func (letter *deadLetter) open() error {
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if letter.appendRecords {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	}
+
+	file, err := os.OpenFile(letter.path, flags, 0o644)
+	if err != nil {
+		return err
+	}
+
+	letter.file = file
+	letter.writer = csv.NewWriter(file)
+	letter.writer.Comma = '\t'
+
+	// the header is already there if records are appended
+	if info, err := file.Stat(); err == nil && info.Size() > 0 {
+		return nil
+	}
+
+	header := append(append([]string{}, letter.header...), "dead_letter_line", "dead_letter_reason")
+	return letter.writer.Write(header)
+}
+
+func (letter *deadLetter) write(line int, record []string, reason string) {
+	log.Printf("Rejected record on line %d: %s\n", line, reason)
+
+	letter.mutex.Lock()
+	defer letter.mutex.Unlock()
+
+	letter.count++
+	if letter.collect {
+		letter.reasons = append(letter.reasons, fmt.Sprintf("line %d: %s", line, reason))
+	}
+	if letter.path == "" {
+		return
+	}
+
+	if letter.writer == nil {
+		if err := letter.open(); err != nil {
+			log.Printf("Couldn't open dead-letter file \"%s\": %s\n", letter.path, err)
+			letter.path = ""
+			return
+		}
+	}
+
+	row := append(append([]string{}, record...), fmt.Sprint(line), reason)
+	if err := letter.writer.Write(row); err != nil {
+		log.Printf("Couldn't write record on line %d to dead-letter file: %s\n", line, err)
+	}
+}
+
+func (letter *deadLetter) rejected() uint64 {
+	letter.mutex.Lock()
+	defer letter.mutex.Unlock()
+
+	return letter.count
+}
+
+// Writes buffered records through to the disk. Rejected records count as done in the
+// checkpoint, so they are synced before it's saved, or a resumed import would lose them.
+func (letter *deadLetter) sync() error {
+	letter.mutex.Lock()
+	defer letter.mutex.Unlock()
+
+	if letter.writer == nil {
+		return nil
+	}
+
+	letter.writer.Flush()
+	if err := letter.writer.Error(); err != nil {
+		return err
+	}
+	return letter.file.Sync()
+}
+
+func (letter *deadLetter) Close() error {
+	letter.mutex.Lock()
+	defer letter.mutex.Unlock()
+
+	if letter.writer == nil {
+		return nil
+	}
+
+	letter.writer.Flush()
+	if err := letter.writer.Error(); err != nil {
+		letter.file.Close()
+		return err
+	}
+
+	return letter.file.Close()
+}
+
+func parseFailureReason(err error) string {
+	return fmt.Sprintf("parse: %s", err)
+}
+
+func bulkFailureReason(response esutil.BulkIndexerResponseItem, err error) string {
+	if err != nil {
+		return fmt.Sprintf("bulk: %s", err)
+	}
+
+	kind := "index"
+	switch {
+	case response.Status == http.StatusTooManyRequests:
+		kind = "rejected"
+	case response.Status == http.StatusBadRequest:
+		kind = "mapping"
+	}
+
+	return fmt.Sprintf("%s: %d %s: %s", kind, response.Status, response.Error.Type, response.Error.Reason)
+}
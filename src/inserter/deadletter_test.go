@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/elastic/go-elasticsearch/v8/esutil"
+)
+
+// records rejected before a checkpoint is saved are on the disk, even if the import is killed before Close
+func TestDeadLetterSync(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dead_letter.tsv")
+	letter := newDeadLetter(path)
+	letter.setHeader([]string{"ID", "Name"})
+
+	if err := letter.sync(); err != nil {
+		t.Fatalf("sync before any rejected record: %s", err)
+	}
+
+	letter.write(2, []string{"1", "Place"}, "validation: no address")
+	if err := letter.sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "ID\tName\tdead_letter_line\tdead_letter_reason\n1\tPlace\t2\tvalidation: no address\n"
+	if string(content) != want {
+		t.Errorf("dead-letter file is %q, want %q", content, want)
+	}
+
+	letter.write(3, []string{"2", "Place"}, "parse: bad id")
+	if err := letter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	content, err = os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(string(content), "\n"); lines != 3 {
+		t.Errorf("dead-letter file has %d lines after close, want 3", lines)
+	}
+}
+
+func TestBulkFailureReason(t *testing.T) {
+	failure := func(status int, kind, reason string) esutil.BulkIndexerResponseItem {
+		item := esutil.BulkIndexerResponseItem{Status: status}
+		item.Error.Type = kind
+		item.Error.Reason = reason
+		return item
+	}
+
+	tests := []struct {
+		name     string
+		response esutil.BulkIndexerResponseItem
+		err      error
+		want     string
+	}{
+		{name: "request failed", err: errors.New("connection refused"), want: "bulk: connection refused"},
+		{name: "queue is full", response: failure(http.StatusTooManyRequests, "es_rejected_execution_exception", "queue full"), want: "rejected: 429 es_rejected_execution_exception: queue full"},
+		{name: "mapping", response: failure(http.StatusBadRequest, "mapper_parsing_exception", "failed to parse field [location]"), want: "mapping: 400 mapper_parsing_exception: failed to parse field [location]"},
+		{name: "other", response: failure(http.StatusInternalServerError, "exception", "shard failed"), want: "index: 500 exception: shard failed"},
+	}
+
+	for _, test := range tests {
+		if got := bulkFailureReason(test.response, test.err); got != test.want {
+			t.Errorf("%s: reason = %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+// every rejected row is written as it was read, followed by its line and the reason,
+// whether it was rejected by the inserter or by elasticsearch
+func TestDeadLetterContents(t *testing.T) {
+	fake := &fakeElastic{
+		indexed:  make(map[string]bool),
+		failures: map[string]int{"2": http.StatusBadRequest, "3": http.StatusTooManyRequests},
+	}
+	importPipeline := newTestPipeline(t, fake, 5_000_000)
+
+	header := "ID\tName\tAddress\tPhone\tLongitude\tLatitude"
+	rows := []string{
+		"1\tPlace 1\tulitsa 1\t(495) 676-55-35\t37.61\t55.71",
+		"2\tPlace 2\tulitsa 2\t(495) 676-55-35\t37.62\t55.72",
+		"3\tPlace 3\tulitsa 3\t(495) 676-55-35\t37.63\t55.73",
+		"x\tPlace 4\tulitsa 4\t(495) 676-55-35\t37.64\t55.74",
+		"5\tPlace 5\tulitsa 5\t(495) 676-55-35\t37.65\t95.75",
+	}
+	reader, err := newRecordReader(strings.NewReader(header+"\n"+strings.Join(rows, "\n")+"\n"), "data.csv", "", defaultSchema())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	summary, err := importPipeline.run(reader, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := importPipeline.deadLetters.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if summary.Inserted != 1 || importPipeline.deadLetters.rejected() != 4 {
+		t.Errorf("inserted %d and rejected %d records, want 1 and 4", summary.Inserted, importPipeline.deadLetters.rejected())
+	}
+
+	file, err := os.Open(importPipeline.deadLetters.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	deadLetterReader := csv.NewReader(file)
+	deadLetterReader.Comma = '\t'
+	records, err := deadLetterReader.ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the file can be imported again, its extra columns are ignored
+	if got, want := strings.Join(records[0], "\t"), header+"\tdead_letter_line\tdead_letter_reason"; got != want {
+		t.Errorf("header = %q, want %q", got, want)
+	}
+
+	// workers and bulk requests finish in any order
+	rejected := records[1:]
+	sort.Slice(rejected, func(i, j int) bool { return rejected[i][6] < rejected[j][6] })
+
+	tests := []struct {
+		row    string
+		line   string
+		reason string
+	}{
+		{row: rows[1], line: "3", reason: "mapping: 400 fake_exception: Bad Request"},
+		{row: rows[2], line: "4", reason: "rejected: 429 fake_exception: Too Many Requests"},
+		{row: rows[3], line: "5", reason: "parse: invalid id \"x\""},
+		{row: rows[4], line: "6", reason: "validation: "},
+	}
+	if len(rejected) != len(tests) {
+		t.Fatalf("dead-letter file has %d records, want %d: %q", len(rejected), len(tests), rejected)
+	}
+	for i, test := range tests {
+		record := rejected[i]
+		if got := strings.Join(record[:6], "\t"); got != test.row {
+			t.Errorf("record of line %s = %q, want %q", test.line, got, test.row)
+		}
+		if record[6] != test.line || !strings.HasPrefix(record[7], test.reason) {
+			t.Errorf("line and reason = %q, %q, want %q and %q...", record[6], record[7], test.line, test.reason)
+		}
+	}
+}
+
+// a resumed import appends its rejected records under the header written by the interrupted one
+func TestDeadLetterAppendsOnResume(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dead_letter.tsv")
+
+	for i, reason := range []string{"parse: first", "parse: second"} {
+		letter := newDeadLetter(path)
+		letter.appendRecords = i > 0
+		letter.setHeader([]string{"ID", "Name"})
+		letter.write(i+2, []string{"x", "Place"}, reason)
+		if err := letter.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "ID\tName\tdead_letter_line\tdead_letter_reason\nx\tPlace\t2\tparse: first\nx\tPlace\t3\tparse: second\n"
+	if string(content) != want {
+		t.Errorf("dead-letter file is %q, want %q", content, want)
+	}
+}
@@ -0,0 +1,439 @@
+package main
+
+import (
+	"common"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"paginate"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/elastic/go-elasticsearch/v8"
+)
+
+// number of places updated or deleted by a single bulk request of the merge
+const mergeBatchSize = 500
+
+// place with the name prepared for comparison
+type dedupCandidate struct {
+	place common.Place
+	name  []rune
+}
+
+// Lowercases the name and drops punctuation and quotes, so "Кафе «Пушкин»"
+// and "кафе пушкин" are the same name.
+func normalizeName(name string) []rune {
+	cleaned := strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return ' '
+	}, strings.ReplaceAll(strings.ToLower(name), "ё", "е"))
+
+	return []rune(collapseWhitespace(cleaned))
+}
+
+// 1 minus the edit distance relative to the longer name, 1 for equal names
+func nameSimilarity(first, second []rune) float64 {
+	if len(first) == 0 && len(second) == 0 {
+		return 1
+	}
+
+	previous := make([]int, len(second)+1)
+	current := make([]int, len(second)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(first); i++ {
+		current[0] = i
+		for j := 1; j <= len(second); j++ {
+			substitution := previous[j-1]
+			if first[i-1] != second[j-1] {
+				substitution++
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, substitution)
+		}
+		previous, current = current, previous
+	}
+
+	return 1 - float64(previous[len(second)])/float64(max(len(first), len(second)))
+}
+
+// Groups places whose names are similar enough and which are at most the
+// distance apart. Places are bucketed into cells as large as the distance, so
+// only places of neighbouring cells are compared. Every cluster is formed around
+// its canonical place and the rest are compared with it rather than with each
+// other, so a chain of places, each similar to the next one, isn't merged whole.
+func findDuplicates(places []common.Place, distance, similarity float64) [][]common.Place {
+	candidates := make([]dedupCandidate, len(places))
+	maxLatitude := 0.0
+	for i, place := range places {
+		candidates[i] = dedupCandidate{place: place, name: normalizeName(place.Name)}
+		maxLatitude = math.Max(maxLatitude, math.Abs(place.Location.Latitude))
+	}
+
+	// places which would be canonical go first, so they become centers of clusters
+	sort.Slice(candidates, func(i, j int) bool {
+		return isMoreCanonical(candidates[i].place, candidates[j].place)
+	})
+
+	// a degree of longitude is the shortest at the highest latitude
+	cellLatitude := distance / 111_320
+	cellLongitude := cellLatitude / math.Cos(math.Min(maxLatitude, 89)*math.Pi/180)
+
+	type cell struct{ latitude, longitude int }
+	cellOf := func(location common.Location) cell {
+		return cell{
+			latitude:  int(math.Floor(location.Latitude / cellLatitude)),
+			longitude: int(math.Floor(location.Longitude / cellLongitude)),
+		}
+	}
+
+	cells := make(map[cell][]int)
+	for i, candidate := range candidates {
+		key := cellOf(candidate.place.Location)
+		cells[key] = append(cells[key], i)
+	}
+
+	clustered := make([]bool, len(candidates))
+	clusters := make([][]common.Place, 0)
+	for i, candidate := range candidates {
+		if clustered[i] {
+			continue
+		}
+		clustered[i] = true
+
+		cluster := []common.Place{candidate.place}
+		key := cellOf(candidate.place.Location)
+		for latitude := key.latitude - 1; latitude <= key.latitude+1; latitude++ {
+			for longitude := key.longitude - 1; longitude <= key.longitude+1; longitude++ {
+				for _, j := range cells[cell{latitude, longitude}] {
+					other := candidates[j]
+					if clustered[j] ||
+						common.DistanceMeters(candidate.place.Location, other.place.Location) > distance ||
+						nameSimilarity(candidate.name, other.name) < similarity {
+						continue
+					}
+					clustered[j] = true
+					cluster = append(cluster, other.place)
+				}
+			}
+		}
+
+		if len(cluster) > 1 {
+			sortByCanonical(cluster)
+			clusters = append(clusters, cluster)
+		}
+	}
+	sort.Slice(clusters, func(i, j int) bool { return clusters[i][0].ID < clusters[j][0].ID })
+
+	return clusters
+}
+
+// number of optional fields the place has
+func completeness(place common.Place) int {
+	filled := 0
+	for _, present := range []bool{
+		place.Phone != "",
+		place.District != "",
+		len(place.Cuisine) > 0,
+		place.OpeningHours != nil,
+		place.PriceLevel > 0,
+		place.Website != "",
+	} {
+		if present {
+			filled++
+		}
+	}
+	return filled
+}
+
+// The canonical place is the most reviewed one, then the most complete one,
+// then the one with the smallest id, so repeated runs pick the same place.
+func isMoreCanonical(first, second common.Place) bool {
+	if first.RatingCount != second.RatingCount {
+		return first.RatingCount > second.RatingCount
+	}
+	if completeness(first) != completeness(second) {
+		return completeness(first) > completeness(second)
+	}
+	return first.ID < second.ID
+}
+
+// the canonical place goes first
+func sortByCanonical(cluster []common.Place) {
+	sort.Slice(cluster, func(i, j int) bool { return isMoreCanonical(cluster[i], cluster[j]) })
+}
+
+type dedupReportPlace struct {
+	ID         uint64  `json:"id"`
+	Name       string  `json:"name"`
+	Address    string  `json:"address"`
+	DistanceM  float64 `json:"distance_m"`
+	Similarity float64 `json:"similarity"`
+}
+
+type dedupReportCluster struct {
+	Canonical  dedupReportPlace   `json:"canonical"`
+	Duplicates []dedupReportPlace `json:"duplicates"`
+}
+
+// written for a review before the merge, distance and similarity are relative to the canonical place
+type dedupReport struct {
+	Index      string               `json:"index"`
+	Places     int                  `json:"places"`
+	DistanceM  float64              `json:"distance_m"`
+	Similarity float64              `json:"similarity"`
+	Duplicates int                  `json:"duplicates"`
+	Merged     bool                 `json:"merged"`
+	Clusters   []dedupReportCluster `json:"clusters"`
+}
+
+func newDedupReportCluster(cluster []common.Place) dedupReportCluster {
+	canonical := cluster[0]
+	canonicalName := normalizeName(canonical.Name)
+
+	reportPlace := func(place common.Place) dedupReportPlace {
+		return dedupReportPlace{
+			ID:         place.ID,
+			Name:       place.Name,
+			Address:    place.Address,
+			DistanceM:  math.Round(common.DistanceMeters(canonical.Location, place.Location)*10) / 10,
+			Similarity: math.Round(nameSimilarity(canonicalName, normalizeName(place.Name))*100) / 100,
+		}
+	}
+
+	reportCluster := dedupReportCluster{Canonical: reportPlace(canonical)}
+	for _, duplicate := range cluster[1:] {
+		reportCluster.Duplicates = append(reportCluster.Duplicates, reportPlace(duplicate))
+	}
+	return reportCluster
+}
+
+func writeDedupReport(path string, report dedupReport) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(file)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
+
+// ids the canonical place stands for, including ones merged into its duplicates before
+func mergedIDs(cluster []common.Place) []uint64 {
+	ids := make([]uint64, 0)
+	seen := map[uint64]bool{cluster[0].ID: true}
+	for i, place := range cluster {
+		candidates := place.MergedIDs
+		if i > 0 {
+			candidates = append([]uint64{place.ID}, candidates...)
+		}
+
+		for _, id := range candidates {
+			if !seen[id] {
+				seen[id] = true
+				ids = append(ids, id)
+			}
+		}
+	}
+
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// Moves reviews of duplicates to their canonical places before the duplicates are
+// deleted, so an interrupted merge finds the same clusters again and loses no review.
+func moveReviews(reviews paginate.ElasticReviews, clusters [][]common.Place) error {
+	exists, err := reviews.Client.Indices.Exists([]string{reviews.Index})
+	if err != nil {
+		return err
+	}
+	exists.Body.Close()
+	if exists.StatusCode == http.StatusNotFound {
+		log.Printf("Index \"%s\" doesn't exist, there are no reviews to move\n", reviews.Index)
+		return nil
+	}
+
+	moved := 0
+	for _, cluster := range clusters {
+		reviewed := false
+		for _, duplicate := range cluster[1:] {
+			reviewed = reviewed || duplicate.RatingCount > 0
+		}
+		if !reviewed {
+			continue
+		}
+
+		if err := reviews.MoveReviews(mergedIDs(cluster), cluster[0].ID); err != nil {
+			return fmt.Errorf("couldn't move reviews to place %d: %w", cluster[0].ID, err)
+		}
+		moved++
+	}
+	log.Printf("Moved reviews of duplicates to %d canonical places\n", moved)
+
+	return nil
+}
+
+// Records merged ids in canonical places and deletes their duplicates. Requests
+// are sent in batches, each one is visible to searches once it's done.
+func mergeClusters(client *elasticsearch.Client, index string, clusters [][]common.Place) error {
+	for start := 0; start < len(clusters); start += mergeBatchSize {
+		var body strings.Builder
+		for _, cluster := range clusters[start:min(start+mergeBatchSize, len(clusters))] {
+			update, err := json.Marshal(map[string]any{"doc": map[string]any{"merged_ids": mergedIDs(cluster)}})
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(&body, "{\"update\": {\"_id\": %q}}\n%s\n", fmt.Sprint(cluster[0].ID), update)
+
+			for _, duplicate := range cluster[1:] {
+				fmt.Fprintf(&body, "{\"delete\": {\"_id\": %q}}\n", fmt.Sprint(duplicate.ID))
+			}
+		}
+
+		response, err := client.Bulk(
+			strings.NewReader(body.String()),
+			client.Bulk.WithIndex(index),
+			client.Bulk.WithRefresh("wait_for"),
+		)
+		if err != nil {
+			return err
+		}
+
+		var result struct {
+			Errors bool `json:"errors"`
+			Items  []map[string]struct {
+				ID     string          `json:"_id"`
+				Status int             `json:"status"`
+				Error  json.RawMessage `json:"error"`
+			} `json:"items"`
+		}
+		if response.IsError() {
+			response.Body.Close()
+			return fmt.Errorf("%s", response)
+		}
+		err = json.NewDecoder(response.Body).Decode(&result)
+		response.Body.Close()
+		if err != nil {
+			return err
+		}
+
+		if result.Errors {
+			for _, item := range result.Items {
+				for action, outcome := range item {
+					// duplicates deleted by an earlier interrupted merge are already gone
+					if outcome.Error != nil && !(action == "delete" && outcome.Status == 404) {
+						return fmt.Errorf("couldn't %s place %s: %s", action, outcome.ID, outcome.Error)
+					}
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// Finds clusters of duplicates among places behind the alias and writes a report,
+// with the merge flag merges them into canonical places along with their reviews.
+// Imports skip records of merged ids, so merged duplicates don't come back.
+func dedup(client *elasticsearch.Client, parsedArgs map[string]any) error {
+	alias := parsedArgs["index"].(string)
+	distance := parsedArgs["dedup-distance"].(float64)
+	similarity := parsedArgs["dedup-similarity"].(float64)
+	merge := parsedArgs["merge"].(bool)
+
+	if distance <= 0 {
+		return fmt.Errorf("flag \"dedup-distance\" must be positive, got %v", distance)
+	}
+	if similarity <= 0 || similarity > 1 {
+		return fmt.Errorf("flag \"dedup-similarity\" must be above 0 and at most 1, got %v", similarity)
+	}
+
+	current, err := getCurrentMapping(client, alias)
+	if err != nil {
+		return err
+	}
+	if current == nil {
+		return fmt.Errorf("index \"%s\" doesn't exist, run import first", alias)
+	}
+	if _, exists := properties(current.mappings)["merged_ids"]; merge && !exists {
+		return fmt.Errorf("index \"%s\" has no merged_ids field, run migrate first", current.index)
+	}
+
+	places := make([]common.Place, 0)
+	err = scrollDocuments(client, current.index, nil, func(id string, source json.RawMessage) error {
+		var place common.Place
+		if err := json.Unmarshal(source, &place); err != nil {
+			return fmt.Errorf("couldn't decode place %s: %w", id, err)
+		}
+
+		places = append(places, place)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	clusters := findDuplicates(places, distance, similarity)
+
+	report := dedupReport{
+		Index:      current.index,
+		Places:     len(places),
+		DistanceM:  distance,
+		Similarity: similarity,
+		Clusters:   make([]dedupReportCluster, len(clusters)),
+	}
+	for i, cluster := range clusters {
+		report.Clusters[i] = newDedupReportCluster(cluster)
+		report.Duplicates += len(cluster) - 1
+	}
+
+	if merge && len(clusters) > 0 {
+		reviews := paginate.ElasticReviews{
+			Client:      client,
+			Index:       parsedArgs["reviews-index"].(string),
+			PlacesIndex: current.index,
+		}
+		if err := moveReviews(reviews, clusters); err != nil {
+			return err
+		}
+
+		if err := mergeClusters(client, current.index, clusters); err != nil {
+			return err
+		}
+		report.Merged = true
+	}
+
+	reportPath := parsedArgs["dedup-report"].(string)
+	if err := writeDedupReport(reportPath, report); err != nil {
+		return err
+	}
+
+	action := "found"
+	if report.Merged {
+		action = "merged"
+	}
+	log.Printf(
+		"Checked %d places of \"%s\", %s %d duplicates in %d clusters, report is written to %s\n",
+		len(places),
+		current.index,
+		action,
+		report.Duplicates,
+		len(clusters),
+		reportPath,
+	)
+
+	return nil
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"common"
+	"math"
+	"testing"
+)
+
+func TestNameSimilarity(t *testing.T) {
+	tests := []struct {
+		first  string
+		second string
+		want   float64
+	}{
+		{first: "Кафе «Пушкин»", second: "кафе пушкин", want: 1},
+		{first: "Ёлки-Палки", second: "елки палки", want: 1},
+		{first: "", second: "", want: 1},
+		{first: "Шоколадница", second: "Шоколадниц", want: 1 - 1.0/11},
+		{first: "abc", second: "xyz", want: 0},
+		{first: "Kitten", second: "Sitting", want: 1 - 3.0/7},
+	}
+
+	for _, test := range tests {
+		got := nameSimilarity(normalizeName(test.first), normalizeName(test.second))
+		if math.Abs(got-test.want) > 1e-9 {
+			t.Errorf("nameSimilarity(%q, %q) = %v, want %v", test.first, test.second, got, test.want)
+		}
+		if reverse := nameSimilarity(normalizeName(test.second), normalizeName(test.first)); reverse != got {
+			t.Errorf("nameSimilarity(%q, %q) = %v differs from the reverse %v", test.second, test.first, reverse, got)
+		}
+	}
+}
+
+func TestFindDuplicates(t *testing.T) {
+	// a degree of latitude is about 111 km, so 0.0005 is about 56 m
+	place := func(id uint64, name string, latitude float64, ratings int) common.Place {
+		return common.Place{
+			ID:          id,
+			Name:        name,
+			Location:    common.Location{Latitude: latitude, Longitude: 37.6},
+			RatingCount: ratings,
+		}
+	}
+
+	tests := []struct {
+		name   string
+		places []common.Place
+		want   [][]uint64
+	}{
+		{
+			name: "same name nearby",
+			places: []common.Place{
+				place(1, "Кафе Пушкин", 55.75, 0),
+				place(2, "кафе «Пушкин»", 55.7505, 0),
+			},
+			want: [][]uint64{{1, 2}},
+		},
+		{
+			name: "same name far away",
+			places: []common.Place{
+				place(1, "Кафе Пушкин", 55.75, 0),
+				place(2, "Кафе Пушкин", 55.76, 0),
+			},
+			want: [][]uint64{},
+		},
+		{
+			name: "different names nearby",
+			places: []common.Place{
+				place(1, "Кафе Пушкин", 55.75, 0),
+				place(2, "Шоколадница", 55.75, 0),
+			},
+			want: [][]uint64{},
+		},
+		{
+			name: "most reviewed place is canonical",
+			places: []common.Place{
+				place(1, "Кафе Пушкин", 55.75, 0),
+				place(2, "Кафе Пушкин", 55.75, 3),
+			},
+			want: [][]uint64{{2, 1}},
+		},
+		{
+			// 1 and 3 are 112 m apart, so only the place next to the canonical one joins it
+			name: "chain isn't merged transitively",
+			places: []common.Place{
+				place(1, "Кафе Пушкин", 55.75, 0),
+				place(2, "Кафе Пушкин", 55.7507, 0),
+				place(3, "Кафе Пушкин", 55.751, 0),
+			},
+			want: [][]uint64{{1, 2}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			clusters := findDuplicates(test.places, 100, 0.85)
+
+			got := make([][]uint64, len(clusters))
+			for i, cluster := range clusters {
+				for _, place := range cluster {
+					got[i] = append(got[i], place.ID)
+				}
+			}
+
+			if len(got) != len(test.want) {
+				t.Fatalf("clusters = %v, want %v", got, test.want)
+			}
+			for i := range got {
+				if len(got[i]) != len(test.want[i]) {
+					t.Fatalf("clusters = %v, want %v", got, test.want)
+				}
+				for j := range got[i] {
+					if got[i][j] != test.want[i][j] {
+						t.Fatalf("clusters = %v, want %v", got, test.want)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestMergedIDs(t *testing.T) {
+	cluster := []common.Place{
+		{ID: 5, MergedIDs: []uint64{9}},
+		{ID: 3, MergedIDs: []uint64{7, 9}},
+		{ID: 1},
+	}
+
+	if got := mergedIDs(cluster); len(got) != 4 || got[0] != 1 || got[1] != 3 || got[2] != 7 || got[3] != 9 {
+		t.Errorf("mergedIDs = %v, want [1 3 7 9]", got)
+	}
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"common"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cuisines are separated by semicolons or commas and stored lowercased
+func parseCuisine(value string) []string {
+	cuisines := make([]string, 0)
+	seen := make(map[string]bool)
+
+	for _, cuisine := range strings.FieldsFunc(value, func(r rune) bool { return r == ';' || r == ',' }) {
+		cuisine = strings.ToLower(collapseWhitespace(cuisine))
+		if cuisine == "" || seen[cuisine] {
+			continue
+		}
+		seen[cuisine] = true
+		cuisines = append(cuisines, cuisine)
+	}
+
+	if len(cuisines) == 0 {
+		return nil
+	}
+	return cuisines
+}
+
+// either a number from 1 to 4 or as many currency signs, e.g. "$$"
+func parsePriceLevel(value string) (int, error) {
+	if value == "" {
+		return 0, nil
+	}
+
+	level, err := strconv.Atoi(value)
+	if err != nil {
+		signs := []rune(value)
+		for _, sign := range signs {
+			if sign != signs[0] || !strings.ContainsRune("$€₽", sign) {
+				return 0, fmt.Errorf("invalid price level %q", value)
+			}
+		}
+		level = len(signs)
+	}
+
+	if level < 1 || level > common.MaxPriceLevel {
+		return 0, fmt.Errorf("price level %q is out of range from 1 to %d", value, common.MaxPriceLevel)
+	}
+	return level, nil
+}
+
+// either RFC 3339 or a date like "2023-09-01"
+func parseTimestamp(value string) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	for _, layout := range []string{time.RFC3339, time.DateOnly} {
+		if timestamp, err := time.Parse(layout, value); err == nil {
+			return &timestamp, nil
+		}
+	}
+	return nil, fmt.Errorf("invalid timestamp %q", value)
+}
+
+// Opening hours are either JSON as stored in the index or written like
+// "Mo-Fr 09:00-22:00; Sa,Su 12:00-14:00,15:00-02:00; 2026-01-01 off",
+// where days are ranges or lists, closing time before opening time means
+// after midnight and dates are exceptions, like holidays, to weekly hours.
+func parseOpeningHours(value string) (*common.OpeningHours, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	var hours common.OpeningHours
+	if strings.HasPrefix(value, "{") {
+		if err := json.Unmarshal([]byte(value), &hours); err != nil {
+			return nil, fmt.Errorf("invalid opening hours: %w", err)
+		}
+	} else {
+		for _, rule := range strings.Split(value, ";") {
+			rule = strings.TrimSpace(rule)
+			if rule == "" {
+				continue
+			}
+
+			days, times, found := strings.Cut(rule, " ")
+			if !found {
+				return nil, fmt.Errorf("opening hours rule %q must look like \"Mo-Fr 09:00-22:00\"", rule)
+			}
+			times = strings.TrimSpace(times)
+
+			if _, err := time.Parse(time.DateOnly, days); err == nil {
+				exceptions, err := parseOpeningException(days, times)
+				if err != nil {
+					return nil, err
+				}
+				hours.Exceptions = append(hours.Exceptions, exceptions...)
+				continue
+			}
+
+			weekdays, err := parseWeekdays(days)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, timeRange := range strings.Split(times, ",") {
+				open, close, found := strings.Cut(strings.TrimSpace(timeRange), "-")
+				if !found {
+					return nil, fmt.Errorf("time range %q must look like \"09:00-22:00\"", timeRange)
+				}
+
+				for _, day := range weekdays {
+					hours.Weekly = append(hours.Weekly, common.OpeningInterval{Day: day, Open: open, Close: close})
+				}
+			}
+		}
+	}
+
+	for _, interval := range hours.Weekly {
+		if interval.Day < time.Sunday || interval.Day > time.Saturday {
+			return nil, fmt.Errorf("invalid day %d of opening hours", interval.Day)
+		}
+		if !isClockTime(interval.Open, false) || !isClockTime(interval.Close, true) {
+			return nil, fmt.Errorf("invalid opening hours %s-%s", interval.Open, interval.Close)
+		}
+	}
+
+	for _, exception := range hours.Exceptions {
+		if _, err := time.Parse(time.DateOnly, exception.Date); err != nil {
+			return nil, fmt.Errorf("invalid date %q of opening hours exception", exception.Date)
+		}
+		if !exception.Closed && (!isClockTime(exception.Open, false) || !isClockTime(exception.Close, true)) {
+			return nil, fmt.Errorf("invalid opening hours %s-%s on %s", exception.Open, exception.Close, exception.Date)
+		}
+	}
+
+	if len(hours.Weekly) == 0 && len(hours.Exceptions) == 0 {
+		return nil, nil
+	}
+	return &hours, nil
+}
+
+// hours of a date like "10:00-18:00,19:00-23:00", "off" or "closed" if it's a day off
+func parseOpeningException(date, times string) ([]common.OpeningException, error) {
+	if strings.EqualFold(times, "off") || strings.EqualFold(times, "closed") {
+		return []common.OpeningException{{Date: date, Closed: true}}, nil
+	}
+
+	exceptions := make([]common.OpeningException, 0, 1)
+	for _, timeRange := range strings.Split(times, ",") {
+		open, close, found := strings.Cut(strings.TrimSpace(timeRange), "-")
+		if !found {
+			return nil, fmt.Errorf("time range %q must look like \"10:00-18:00\" or \"off\"", timeRange)
+		}
+		exceptions = append(exceptions, common.OpeningException{Date: date, Open: open, Close: close})
+	}
+
+	return exceptions, nil
+}
+
+// days like "Mo-Fr", "Sa,Su" or "Fr-Mo", which wraps around the week
+func parseWeekdays(value string) ([]time.Weekday, error) {
+	weekdays := make([]time.Weekday, 0, 7)
+
+	for _, days := range strings.Split(value, ",") {
+		first, last, isRange := strings.Cut(strings.TrimSpace(days), "-")
+
+		from, err := common.ParseWeekday(first)
+		if err != nil {
+			return nil, err
+		}
+		to := from
+		if isRange {
+			if to, err = common.ParseWeekday(last); err != nil {
+				return nil, err
+			}
+		}
+
+		for day := from; ; day = (day + 1) % 7 {
+			weekdays = append(weekdays, day)
+			if day == to {
+				break
+			}
+		}
+	}
+
+	return weekdays, nil
+}
+
+// "24:00" is allowed as closing time only
+func isClockTime(value string, isClosing bool) bool {
+	if isClosing && value == "24:00" {
+		return true
+	}
+
+	_, err := time.Parse("15:04", value)
+	return err == nil && len(value) == len("15:04")
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"common"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseCuisine(t *testing.T) {
+	tests := []struct {
+		value string
+		want  []string
+	}{
+		{value: "", want: nil},
+		{value: "Italian", want: []string{"italian"}},
+		{value: "Italian; Pizza,  Fast   Food", want: []string{"italian", "pizza", "fast food"}},
+		{value: "Italian;italian;ITALIAN", want: []string{"italian"}},
+		{value: " ; , ", want: nil},
+	}
+
+	for _, test := range tests {
+		if got := parseCuisine(test.value); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("parseCuisine(%q) = %q, want %q", test.value, got, test.want)
+		}
+	}
+}
+
+func TestParsePriceLevel(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    int
+		wantErr bool
+	}{
+		{value: "", want: 0},
+		{value: "1", want: 1},
+		{value: "4", want: 4},
+		{value: "$$", want: 2},
+		{value: "€€€", want: 3},
+		{value: "₽", want: 1},
+		{value: "0", wantErr: true},
+		{value: "5", wantErr: true},
+		{value: "$$$$$", wantErr: true},
+		{value: "$€", wantErr: true},
+		{value: "cheap", wantErr: true},
+	}
+
+	for _, test := range tests {
+		got, err := parsePriceLevel(test.value)
+		if got != test.want || (err != nil) != test.wantErr {
+			t.Errorf("parsePriceLevel(%q) = %d, %v, want %d and error %v", test.value, got, err, test.want, test.wantErr)
+		}
+	}
+}
+
+func TestParseTimestamp(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    string
+		wantErr bool
+	}{
+		{value: "", want: ""},
+		{value: "2023-09-01", want: "2023-09-01T00:00:00Z"},
+		{value: "2023-09-01T10:30:00+03:00", want: "2023-09-01T07:30:00Z"},
+		{value: "01.09.2023", wantErr: true},
+		{value: "2023-09-01 10:30", wantErr: true},
+	}
+
+	for _, test := range tests {
+		timestamp, err := parseTimestamp(test.value)
+		got := ""
+		if timestamp != nil {
+			got = timestamp.UTC().Format(time.RFC3339)
+		}
+		if got != test.want || (err != nil) != test.wantErr {
+			t.Errorf("parseTimestamp(%q) = %q, %v, want %q and error %v", test.value, got, err, test.want, test.wantErr)
+		}
+	}
+}
+
+func TestParseOpeningHours(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  string // as written by OpeningHours.String, empty for no hours
+		err   string
+	}{
+		{name: "empty", value: ""},
+		{name: "range", value: "Mo-We 09:00-22:00", want: "Mo 09:00-22:00; Tu 09:00-22:00; We 09:00-22:00"},
+		{
+			name:  "list and several ranges",
+			value: "Sa,Su 12:00-14:00,15:00-02:00",
+			want:  "Sa 12:00-14:00; Su 12:00-14:00; Sa 15:00-02:00; Su 15:00-02:00",
+		},
+		{name: "range around the week", value: "Sa-Mo 10:00-24:00", want: "Sa 10:00-24:00; Su 10:00-24:00; Mo 10:00-24:00"},
+		{
+			name:  "exceptions",
+			value: "Fr 10:00-18:00; 2026-01-01 off; 2026-01-02 12:00-15:00",
+			want:  "Fr 10:00-18:00; 2026-01-01 off; 2026-01-02 12:00-15:00",
+		},
+		{
+			name:  "json",
+			value: `{"weekly": [{"day": 5, "open": "10:00", "close": "18:00"}], "exceptions": [{"date": "2026-01-01", "closed": true}]}`,
+			want:  "Fr 10:00-18:00; 2026-01-01 off",
+		},
+		{name: "only separators", value: " ; "},
+		{name: "no times", value: "Mo-Fr", err: `opening hours rule "Mo-Fr" must look like "Mo-Fr 09:00-22:00"`},
+		{name: "unknown day", value: "Mon 09:00-22:00", err: `unknown day "Mon"`},
+		{name: "no range", value: "Mo 09:00", err: `time range "09:00" must look like "09:00-22:00"`},
+		{name: "invalid time", value: "Mo 9:00-22:00", err: "invalid opening hours 9:00-22:00"},
+		{name: "opening at midnight end", value: "Mo 24:00-02:00", err: "invalid opening hours 24:00-02:00"},
+		{name: "invalid exception", value: "2026-01-01 holiday", err: `time range "holiday" must look like "10:00-18:00" or "off"`},
+		{name: "invalid json day", value: `{"weekly": [{"day": 7, "open": "10:00", "close": "18:00"}]}`, err: "invalid day 7 of opening hours"},
+		{name: "invalid json date", value: `{"weekly": [], "exceptions": [{"date": "01.01.2026", "closed": true}]}`, err: `invalid date "01.01.2026"`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			hours, err := parseOpeningHours(test.value)
+			if test.err != "" {
+				if err == nil || !strings.HasPrefix(err.Error(), test.err) {
+					t.Errorf("parseOpeningHours returned %v, want an error starting with %q", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := ""
+			if hours != nil {
+				got = hours.String()
+			}
+			if got != test.want {
+				t.Errorf("opening hours %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestMappingPlaceDetails(t *testing.T) {
+	recordSchema := &schema{
+		ID:           &column{Name: "id"},
+		Name:         column{Name: "name"},
+		Longitude:    &column{Name: "lon"},
+		Latitude:     &column{Name: "lat"},
+		District:     &column{Name: "district"},
+		Cuisine:      &column{Name: "cuisine"},
+		OpeningHours: &column{Name: "hours"},
+		PriceLevel:   &column{Name: "price"},
+		Website:      &column{Name: "website"},
+		CreatedAt:    &column{Name: "created"},
+		UpdatedAt:    &column{Name: "updated"},
+	}
+	header := []string{"id", "name", "lon", "lat", "district", "cuisine", "hours", "price", "website", "created", "updated"}
+	mapping, err := recordSchema.resolve(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	created := time.Date(2023, 9, 1, 0, 0, 0, 0, time.UTC)
+	updated := time.Date(2024, 3, 2, 10, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		record []string
+		want   common.Place
+		err    string
+	}{
+		{
+			name:   "every detail",
+			record: []string{"1", "Cafe", "37.6", "55.7", " Tverskoy ", "Italian; Pizza", "Mo 10:00-22:00", "$$", "https://cafe.example", "2023-09-01", "2024-03-02T10:00:00Z"},
+			want: common.Place{
+				ID: 1, Name: "Cafe", Location: common.Location{Longitude: 37.6, Latitude: 55.7},
+				District:     "Tverskoy",
+				Cuisine:      []string{"italian", "pizza"},
+				OpeningHours: &common.OpeningHours{Weekly: []common.OpeningInterval{{Day: time.Monday, Open: "10:00", Close: "22:00"}}},
+				PriceLevel:   2,
+				Website:      "https://cafe.example",
+				CreatedAt:    &created,
+				UpdatedAt:    &updated,
+			},
+		},
+		{
+			// details are optional, so empty ones are left unset
+			name:   "no details",
+			record: []string{"2", "Bar", "37.6", "55.7", "", "", "", "", "", "", ""},
+			want:   common.Place{ID: 2, Name: "Bar", Location: common.Location{Longitude: 37.6, Latitude: 55.7}},
+		},
+		{
+			// as are trailing columns a record lacks
+			name:   "short record",
+			record: []string{"3", "Shop", "37.6", "55.7"},
+			want:   common.Place{ID: 3, Name: "Shop", Location: common.Location{Longitude: 37.6, Latitude: 55.7}},
+		},
+		{
+			name:   "invalid price level",
+			record: []string{"4", "Cafe", "37.6", "55.7", "", "", "", "cheap", "", "", ""},
+			err:    `invalid price level "cheap"`,
+		},
+		{
+			name:   "invalid created_at",
+			record: []string{"5", "Cafe", "37.6", "55.7", "", "", "", "", "", "yesterday", ""},
+			err:    `created_at: invalid timestamp "yesterday"`,
+		},
+		{
+			name:   "invalid updated_at",
+			record: []string{"6", "Cafe", "37.6", "55.7", "", "", "", "", "", "", "2024-13-01"},
+			err:    `updated_at: invalid timestamp "2024-13-01"`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			id, err := mapping.ids.generate(test.record)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			place, err := mapping.place(id, test.record)
+			if test.err != "" {
+				if err == nil || err.Error() != test.err {
+					t.Errorf("place returned %v, want %q", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(place, test.want) {
+				t.Errorf("place %+v, want %+v", place, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+)
+
+type dryRunReport struct {
+	mutex     sync.Mutex
+	limit     int
+	documents uint64
+	samples   []json.RawMessage
+}
+
+func (report *dryRunReport) add(marshalizedDocument []byte) {
+	report.mutex.Lock()
+	defer report.mutex.Unlock()
+
+	report.documents++
+	if len(report.samples) < report.limit {
+		report.samples = append(report.samples, marshalizedDocument)
+	}
+}
+
+// parses, validates and transforms the whole data file without touching elasticsearch
+func dryRun(parsedArgs map[string]any, output io.Writer) {
+	dryRunPipeline, reader, input := preparePipeline(parsedArgs)
+	defer input.Close()
+
+	dryRunPipeline.deadLetters = &deadLetter{collect: true}
+	dryRunPipeline.dryRun = &dryRunReport{limit: parsedArgs["samples"].(int)}
+
+	dryRunPipeline.readAndInsertRecords(reader)
+
+	fmt.Fprintf(output, "Index mapping:\n%s\n\n", prepareIndexDefinition(parsedArgs))
+
+	fmt.Fprintf(output, "Readed records: %d\nDocuments to index: %d\nRejected records: %d\n\n",
+		dryRunPipeline.summary.Readed,
+		dryRunPipeline.dryRun.documents,
+		dryRunPipeline.deadLetters.rejected(),
+	)
+
+	fmt.Fprintln(output, "Sample documents:")
+	for _, sample := range dryRunPipeline.dryRun.samples {
+		indented, _ := json.MarshalIndent(sample, "", "  ")
+		fmt.Fprintln(output, string(indented))
+	}
+
+	fmt.Fprintln(output)
+	dryRunPipeline.validator.Report().print(output)
+
+	if len(dryRunPipeline.deadLetters.reasons) > 0 {
+		fmt.Fprintln(output, "\nErrors:")
+		for _, reason := range dryRunPipeline.deadLetters.reasons {
+			fmt.Fprintln(output, reason)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func defaultArgs() map[string]any {
+	parsedArgs := make(map[string]any)
+	for _, arg := range getDefaultFlags() {
+		parsedArgs[arg.Name] = arg.DefaultValue
+	}
+	return parsedArgs
+}
+
+// the dry run reads the whole file and reports what would be indexed, without a single request to elasticsearch
+func TestDryRun(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("unexpected request to elasticsearch: %s %s", r.Method, r.URL)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+	t.Setenv("ELASTICSEARCH_URL", server.URL)
+
+	data := "ID\tName\tAddress\tPhone\tLongitude\tLatitude\n" +
+		"1\tPlace 1\tulitsa 1\t(495) 676-55-35\t37.61\t55.71\n" +
+		"2\tPlace 2\tulitsa 2\t(495) 676-55-35\t37.62\t55.72\n" +
+		"1\tPlace 1 again\tulitsa 1\t(495) 676-55-35\t37.61\t55.71\n" +
+		"3\tPlace 3\tulitsa 3\t(495) 676-55-35\t37.63\t95.73\n" +
+		"4\tPlace 4\tulitsa 4\t(495) 676-55-35\t37.64\t55.74\n"
+	dataPath := filepath.Join(t.TempDir(), "data.csv")
+	if err := os.WriteFile(dataPath, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	parsedArgs := defaultArgs()
+	parsedArgs["data"] = dataPath
+	parsedArgs["samples"] = 2
+	parsedArgs["dead-letter"] = filepath.Join(t.TempDir(), "dead_letter.tsv")
+
+	var output strings.Builder
+	dryRun(parsedArgs, &output)
+	report := output.String()
+
+	for _, want := range []string{
+		"Readed records: 5\nDocuments to index: 3\nRejected records: 2\n",
+		"line 4: duplicate: id 1 was first seen on line 2",
+		"line 5: validation: ",
+		"Normalized phones: 3\n",
+	} {
+		if !strings.Contains(report, want) {
+			t.Errorf("report doesn't contain %q:\n%s", want, report)
+		}
+	}
+
+	samples := report[strings.Index(report, "Sample documents:"):strings.Index(report, "Normalized phones:")]
+	if count := strings.Count(samples, `"name"`); count != 2 {
+		t.Errorf("report has %d sample documents, want 2:\n%s", count, samples)
+	}
+
+	// rejected records are only reported, the dead-letter file is left for real imports
+	if _, err := os.Stat(parsedArgs["dead-letter"].(string)); !os.IsNotExist(err) {
+		t.Errorf("dry run has written the dead-letter file: %v", err)
+	}
+}
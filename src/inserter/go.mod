@@ -1,8 +1,11 @@
 module inserter
 
-go 1.21.1
+go 1.22
 
-require github.com/elastic/go-elasticsearch/v8 v8.13.1
+require (
+	github.com/elastic/go-elasticsearch/v8 v8.13.1
+	github.com/klauspost/compress v1.18.0
+)
 
 require (
 	github.com/elastic/elastic-transport-go/v8 v8.5.0 // indirect
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"strings"
+)
+
+// ids are stored as numbers, keeping them exactly representable
+// as float64 so that search_after values survive JSON decoding
+const maxHashedId = 1<<53 - 1
+
+type idGenerator struct {
+	column      int   // position of the column holding the id
+	hashColumns []int // positions of columns to hash, column is used if empty
+}
+
+func (generator *idGenerator) generate(record []string) (uint64, error) {
+	if len(generator.hashColumns) == 0 {
+		if generator.column >= len(record) {
+			return 0, fmt.Errorf("record has no id column %d", generator.column)
+		}
+
+		id, err := strconv.ParseUint(strings.TrimSpace(record[generator.column]), 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid id %q: %w", record[generator.column], err)
+		}
+
+		return id, nil
+	}
+
+	hash := fnv.New64a()
+	for i, column := range generator.hashColumns {
+		if column >= len(record) {
+			return 0, fmt.Errorf("record has no hash column %d", column)
+		}
+
+		if i > 0 {
+			// unit separator keeps ("ab", "c") and ("a", "bc") apart
+			hash.Write([]byte{0x1f})
+		}
+		hash.Write([]byte(record[column]))
+	}
+
+	return hash.Sum64() & maxHashedId, nil
+}
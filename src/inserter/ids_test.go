@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestIDGenerator(t *testing.T) {
+	tests := []struct {
+		name      string
+		generator idGenerator
+		record    []string
+		want      uint64
+		wantErr   bool
+	}{
+		{name: "column", generator: idGenerator{column: 0}, record: []string{"42", "Place"}, want: 42},
+		{name: "column with spaces", generator: idGenerator{column: 1}, record: []string{"Place", " 42 "}, want: 42},
+		{name: "not a number", generator: idGenerator{column: 0}, record: []string{"forty two"}, wantErr: true},
+		{name: "negative", generator: idGenerator{column: 0}, record: []string{"-1"}, wantErr: true},
+		{name: "missing column", generator: idGenerator{column: 2}, record: []string{"42"}, wantErr: true},
+		{name: "missing hash column", generator: idGenerator{hashColumns: []int{0, 3}}, record: []string{"Place", "ulitsa"}, wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			id, err := test.generator.generate(test.record)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("error = %v, want error %v", err, test.wantErr)
+			}
+			if !test.wantErr && id != test.want {
+				t.Errorf("id = %d, want %d", id, test.want)
+			}
+		})
+	}
+}
+
+// hashed ids don't depend on the position of the row and survive decoding as float64
+func TestIDGeneratorHashes(t *testing.T) {
+	generator := idGenerator{hashColumns: []int{1, 2}}
+
+	tests := []struct {
+		name  string
+		a, b  []string
+		equal bool
+	}{
+		{name: "same columns on other rows", a: []string{"1", "Place", "ulitsa"}, b: []string{"2", "Place", "ulitsa"}, equal: true},
+		{name: "other columns", a: []string{"1", "Place", "ulitsa"}, b: []string{"1", "Place", "prospekt"}},
+		{name: "moved separator", a: []string{"1", "ab", "c"}, b: []string{"1", "a", "bc"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			a, err := generator.generate(test.a)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := generator.generate(test.b)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if (a == b) != test.equal {
+				t.Errorf("ids %d and %d, want equal %v", a, b, test.equal)
+			}
+			if a > maxHashedId || uint64(float64(a)) != a {
+				t.Errorf("id %d isn't exactly representable as float64", a)
+			}
+		})
+	}
+}
+
+// the first row of an id is indexed, the later ones are reported along with the line of the first
+func TestImportReportsDuplicateIDs(t *testing.T) {
+	fake := &fakeElastic{indexed: make(map[string]bool)}
+	importPipeline := newTestPipeline(t, fake, 5_000_000)
+	importPipeline.deadLetters.collect = true
+
+	data := "ID\tName\tAddress\tPhone\tLongitude\tLatitude\n"
+	for _, id := range []int{1, 2, 1, 3, 2} {
+		data += fmt.Sprintf("%d\tPlace %d\tulitsa %d\t(495) 676-55-35\t37.6%d\t55.7%d\n", id, id, id, id, id)
+	}
+	reader, err := newRecordReader(strings.NewReader(data), "data.csv", "", defaultSchema())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	summary, err := importPipeline.run(reader, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if summary.Inserted != 3 || summary.Duplicate != 2 {
+		t.Errorf("inserted %d and found %d duplicates, want 3 and 2", summary.Inserted, summary.Duplicate)
+	}
+	if len(fake.indexed) != 3 {
+		t.Errorf("elasticsearch has %d documents, want 3", len(fake.indexed))
+	}
+
+	want := []string{
+		"line 4: duplicate: id 1 was first seen on line 2",
+		"line 6: duplicate: id 2 was first seen on line 3",
+	}
+	if fmt.Sprint(importPipeline.deadLetters.reasons) != fmt.Sprint(want) {
+		t.Errorf("reasons = %q, want %q", importPipeline.deadLetters.reasons, want)
+	}
+}
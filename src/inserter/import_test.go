@@ -0,0 +1,338 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/elastic/go-elasticsearch/v8/esutil"
+)
+
+// Elasticsearch which acknowledges every bulk item and remembers ids of indexed documents,
+// along with the documents themselves if sources is set. Documents with ids of failures
+// are rejected with the status. Bulk requests wait until blocked is closed if it's set.
+type fakeElastic struct {
+	mutex    sync.Mutex
+	indexed  map[string]bool
+	sources  map[string]json.RawMessage
+	failures map[string]int
+	blocked  chan struct{}
+}
+
+func (fake *fakeElastic) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if fake.blocked != nil {
+		<-fake.blocked
+	}
+
+	w.Header().Set("X-Elastic-Product", "Elasticsearch")
+	w.Header().Set("Content-Type", "application/json")
+
+	if !strings.HasSuffix(r.URL.Path, "/_bulk") {
+		http.NotFound(w, r)
+		return
+	}
+
+	items := make([]map[string]any, 0)
+	hasErrors := false
+	scanner := bufio.NewScanner(r.Body)
+	scanner.Buffer(make([]byte, 1<<20), 1<<20)
+	for scanner.Scan() {
+		var action map[string]struct {
+			ID string `json:"_id"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &action); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		for name, meta := range action {
+			status := http.StatusOK
+			item := map[string]any{"_id": meta.ID}
+			fake.mutex.Lock()
+			switch failure, failed := fake.failures[meta.ID]; {
+			case failed:
+				status = failure
+				item["error"] = map[string]any{"type": "fake_exception", "reason": http.StatusText(failure)}
+				hasErrors = true
+			case name == "index" || name == "create":
+				status = http.StatusCreated
+				fake.indexed[meta.ID] = true
+			case name == "delete":
+				delete(fake.indexed, meta.ID)
+			}
+			fake.mutex.Unlock()
+
+			item["status"] = status
+			items = append(items, map[string]any{name: item})
+
+			// every action but delete is followed by a document
+			if name != "delete" {
+				scanner.Scan()
+				if fake.sources != nil {
+					fake.mutex.Lock()
+					fake.sources[meta.ID] = append(json.RawMessage{}, scanner.Bytes()...)
+					fake.mutex.Unlock()
+				}
+			}
+		}
+	}
+
+	json.NewEncoder(w).Encode(map[string]any{"errors": hasErrors, "items": items})
+}
+
+func newTestPipeline(t *testing.T, fake *fakeElastic, flushBytes int) *pipeline {
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	client, err := elasticsearch.NewClient(elasticsearch.Config{Addresses: []string{server.URL}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	indexer, err := esutil.NewBulkIndexer(esutil.BulkIndexerConfig{
+		Index:      "places",
+		Client:     client,
+		NumWorkers: 2,
+		FlushBytes: flushBytes,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &pipeline{
+		indexer:     &indexer,
+		workers:     2,
+		schema:      defaultSchema(),
+		validator:   newValidator("7", nil),
+		started:     time.Now(),
+		deadLetters: newDeadLetter(filepath.Join(t.TempDir(), "dead_letter.tsv")),
+	}
+}
+
+func testRecords(count int) string {
+	var data strings.Builder
+	data.WriteString("ID\tName\tAddress\tPhone\tLongitude\tLatitude\n")
+	for i := 0; i < count; i++ {
+		fmt.Fprintf(&data, "%d\tPlace %d\tulitsa %d\t(495) 676-55-35\t37.6%d\t55.7%d\n", i, i, i, i, i)
+	}
+	return data.String()
+}
+
+func TestImportSummaryCountsLastFlush(t *testing.T) {
+	tests := []struct {
+		name       string
+		records    int
+		flushBytes int
+	}{
+		// everything is flushed only while the indexer is closed
+		{name: "single flush on close", records: 50, flushBytes: 5_000_000},
+		{name: "several flushes", records: 500, flushBytes: 4096},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fake := &fakeElastic{indexed: make(map[string]bool)}
+			importPipeline := newTestPipeline(t, fake, test.flushBytes)
+
+			reader, err := newRecordReader(strings.NewReader(testRecords(test.records)), "data.csv", "", defaultSchema())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			summary, err := importPipeline.run(reader, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if summary.Readed != uint64(test.records) {
+				t.Errorf("read %d records, want %d", summary.Readed, test.records)
+			}
+			if summary.Inserted != uint64(test.records) {
+				t.Errorf("inserted %d records, want %d", summary.Inserted, test.records)
+			}
+			if len(fake.indexed) != test.records {
+				t.Errorf("elasticsearch has %d documents, want %d", len(fake.indexed), test.records)
+			}
+		})
+	}
+}
+
+// duplicates merged by the dedup command stay merged, however many times the data file is imported
+func TestImportSkipsMergedPlaces(t *testing.T) {
+	fake := &fakeElastic{indexed: make(map[string]bool), sources: make(map[string]json.RawMessage)}
+	importPipeline := newTestPipeline(t, fake, 5_000_000)
+	importPipeline.existing = &existingDocuments{
+		documents: map[string]existingDocument{
+			"1": {ContentHash: "outdated", RatingAverage: 4.5, RatingCount: 2, MergedIDs: []uint64{2, 3}},
+		},
+		seen:   make(map[string]bool),
+		merged: map[string]string{"2": "1", "3": "1"},
+	}
+
+	reader, err := newRecordReader(strings.NewReader(testRecords(5)), "data.csv", "", defaultSchema())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	summary, err := importPipeline.run(reader, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if summary.Merged != 2 || summary.Inserted != 2 || summary.Updated != 1 {
+		t.Errorf("merged %d, inserted %d and updated %d records, want 2, 2 and 1", summary.Merged, summary.Inserted, summary.Updated)
+	}
+	for _, id := range []string{"2", "3"} {
+		if fake.indexed[id] {
+			t.Errorf("merged place %s is indexed again", id)
+		}
+	}
+
+	var canonical document
+	if err := json.Unmarshal(fake.sources["1"], &canonical); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(canonical.MergedIDs) != "[2 3]" || canonical.RatingCount != 2 {
+		t.Errorf("canonical place has merged ids %v and %d ratings, want [2 3] and 2", canonical.MergedIDs, canonical.RatingCount)
+	}
+}
+
+// rows which are rejected keep their documents, only ids absent from the data file are deleted
+func TestDeleteMissingKeepsRejectedRows(t *testing.T) {
+	fake := &fakeElastic{indexed: map[string]bool{"0": true, "1": true, "2": true, "9": true}}
+	importPipeline := newTestPipeline(t, fake, 5_000_000)
+	importPipeline.existing = &existingDocuments{
+		documents: map[string]existingDocument{
+			"0": {ContentHash: "outdated"},
+			"1": {ContentHash: "outdated"},
+			"2": {ContentHash: "outdated"},
+			"9": {ContentHash: "outdated"},
+		},
+		seen:   make(map[string]bool),
+		merged: make(map[string]string),
+	}
+
+	data := "ID\tName\tAddress\tPhone\tLongitude\tLatitude\n" +
+		"0\tPlace 0\tulitsa 0\t(495) 676-55-35\t37.60\t55.70\n" +
+		"1\tPlace 1\tulitsa 1\t(495) 676-55-35\tfar east\t55.71\n" +
+		"2\tPlace 2\tulitsa 2\t(495) 676-55-35\t37.62\t95.72\n"
+	reader, err := newRecordReader(strings.NewReader(data), "data.csv", "", defaultSchema())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	summary, err := importPipeline.run(reader, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if summary.Updated != 1 || summary.Deleted != 1 {
+		t.Errorf("updated %d and deleted %d records, want 1 and 1", summary.Updated, summary.Deleted)
+	}
+	for _, id := range []string{"0", "1", "2"} {
+		if !fake.indexed[id] {
+			t.Errorf("document %s is deleted", id)
+		}
+	}
+	if fake.indexed["9"] {
+		t.Error("document 9, which isn't in the data file, is kept")
+	}
+}
+
+// counts records read from the data file
+type recordCounter struct {
+	recordReader
+	read atomic.Int64
+}
+
+func (reader *recordCounter) Read() ([]string, int, error) {
+	record, line, err := reader.recordReader.Read()
+	if err == nil {
+		reader.read.Add(1)
+	}
+	return record, line, err
+}
+
+// Workers finish records in any order, while every record is indexed once
+// and the checkpoint moves only past records finished along with all before them.
+func TestWorkerPoolFinishesEveryRecord(t *testing.T) {
+	const records = 1000
+
+	for _, workers := range []int{1, 3, 16} {
+		t.Run(fmt.Sprintf("%d workers", workers), func(t *testing.T) {
+			fake := &fakeElastic{indexed: make(map[string]bool)}
+			importPipeline := newTestPipeline(t, fake, 4096)
+			importPipeline.workers = workers
+			importPipeline.checkpoints = newCheckpointTracker(filepath.Join(t.TempDir(), "checkpoint.json"), checkpoint{})
+
+			reader, err := newRecordReader(strings.NewReader(testRecords(records)), "data.csv", "", defaultSchema())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			summary, err := importPipeline.run(reader, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if summary.Inserted != records || len(fake.indexed) != records {
+				t.Errorf("inserted %d records, elasticsearch has %d, want %d", summary.Inserted, len(fake.indexed), records)
+			}
+			state := importPipeline.checkpoints.state
+			if state.Records != records || state.Line != records+1 || state.Inserted != records {
+				t.Errorf("checkpoint is at %d records, line %d, %d inserted, want %d, %d and %d", state.Records, state.Line, state.Inserted, records, records+1, records)
+			}
+		})
+	}
+}
+
+// while elasticsearch doesn't respond, the reader stops once the workers and the indexer
+// are full, instead of reading the whole file into memory
+func TestWorkerPoolBackpressure(t *testing.T) {
+	const records = 10_000
+
+	fake := &fakeElastic{indexed: make(map[string]bool), blocked: make(chan struct{})}
+	importPipeline := newTestPipeline(t, fake, 4096)
+
+	reader, err := newRecordReader(strings.NewReader(testRecords(records)), "data.csv", "", defaultSchema())
+	if err != nil {
+		t.Fatal(err)
+	}
+	counting := &recordCounter{recordReader: reader}
+
+	done := make(chan importSummary)
+	go func() {
+		summary, err := importPipeline.run(counting, false)
+		if err != nil {
+			t.Error(err)
+		}
+		done <- summary
+	}()
+
+	// the reader gets as far as it can
+	read := int64(-1)
+	for read != counting.read.Load() {
+		read = counting.read.Load()
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	// records in flight are in the channel and at workers, in bulk requests of the indexer
+	// workers and in the queue of the indexer, each request is at most flush bytes large
+	if limit := int64(200); read == 0 || read > limit {
+		t.Errorf("%d records are read while elasticsearch is blocked, want from 1 to %d", read, limit)
+	}
+
+	close(fake.blocked)
+	if summary := <-done; summary.Inserted != records {
+		t.Errorf("inserted %d records, want %d", summary.Inserted, records)
+	}
+}
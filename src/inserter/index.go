@@ -0,0 +1,374 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+)
+
+// suffix of the timestamped indices the alias points to, generations
+// created before seconds were added to it are still recognized
+const (
+	generationLayout       = "20060102T150405"
+	legacyGenerationLayout = "20060102T1504"
+)
+
+// number of following seconds tried if a generation of the current second exists
+const maxGenerationAttempts = 10
+
+var errIndexExists = errors.New("index already exists")
+
+func generationName(alias string, t time.Time) string {
+	return fmt.Sprintf("%s-%s", alias, t.Format(generationLayout))
+}
+
+func isGeneration(alias, index string) bool {
+	suffix, found := strings.CutPrefix(index, alias+"-")
+	if !found {
+		return false
+	}
+
+	for _, layout := range []string{generationLayout, legacyGenerationLayout} {
+		if _, err := time.Parse(layout, suffix); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// Creates a generation named after the current time. Names are unique up to
+// a second, so if an import or a migration in the same second has taken
+// the name, the generation is named after one of the following seconds.
+func createGeneration(client *elasticsearch.Client, alias, mapping string) (string, error) {
+	created := time.Now()
+	for attempt := 0; attempt < maxGenerationAttempts; attempt++ {
+		index := generationName(alias, created.Add(time.Duration(attempt)*time.Second))
+
+		err := createIndex(client, index, mapping)
+		if err == nil {
+			return index, nil
+		}
+		if !errors.Is(err, errIndexExists) {
+			return "", fmt.Errorf("can't create index \"%s\": %w", index, err)
+		}
+	}
+
+	return "", fmt.Errorf("can't create a generation of \"%s\", %d generations named after the following seconds exist", alias, maxGenerationAttempts)
+}
+
+func createIndex(client *elasticsearch.Client, index, mapping string) error {
+	response, err := client.Indices.Create(
+		index,
+		client.Indices.Create.WithBody(strings.NewReader(mapping)),
+	)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.IsError() {
+		body, err := io.ReadAll(response.Body)
+		if err != nil {
+			return err
+		}
+
+		var failure struct {
+			Error struct {
+				Type string `json:"type"`
+			} `json:"error"`
+		}
+		if json.Unmarshal(body, &failure) == nil && failure.Error.Type == "resource_already_exists_exception" {
+			return errIndexExists
+		}
+		return fmt.Errorf("[%s] %s", response.Status(), body)
+	}
+	log.Printf("Created index \"%s\"\n", index)
+
+	return nil
+}
+
+func deleteIndices(client *elasticsearch.Client, indices ...string) error {
+	if len(indices) == 0 {
+		return nil
+	}
+
+	response, err := client.Indices.Delete(indices, client.Indices.Delete.WithIgnoreUnavailable(true))
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.IsError() {
+		return fmt.Errorf("%s", response)
+	}
+	log.Printf("Deleted indices %q\n", indices)
+
+	return nil
+}
+
+// returns all generations of the alias sorted from the oldest to the newest one
+func listGenerations(client *elasticsearch.Client, alias string) ([]string, error) {
+	response, err := client.Indices.Get([]string{alias + "-*"})
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.IsError() {
+		return nil, fmt.Errorf("%s", response)
+	}
+
+	var indices map[string]json.RawMessage
+	if err := json.NewDecoder(response.Body).Decode(&indices); err != nil {
+		return nil, err
+	}
+
+	generations := make([]string, 0, len(indices))
+	for index := range indices {
+		if isGeneration(alias, index) {
+			generations = append(generations, index)
+		}
+	}
+	sort.Strings(generations)
+
+	return generations, nil
+}
+
+// whether an index or an alias of the name exists
+func indexExists(client *elasticsearch.Client, index string) (bool, error) {
+	response, err := client.Indices.Exists([]string{index})
+	if err != nil {
+		return false, err
+	}
+	defer response.Body.Close()
+
+	switch response.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	default:
+		return false, fmt.Errorf("%s", response)
+	}
+}
+
+// returns indices the alias currently points to
+// and whether an old-style concrete index occupies the alias name
+func getAliasTargets(client *elasticsearch.Client, alias string) ([]string, bool, error) {
+	response, err := client.Indices.GetAlias(client.Indices.GetAlias.WithName(alias))
+	if err != nil {
+		return nil, false, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode == http.StatusNotFound {
+		exists, err := indexExists(client, alias)
+		return nil, exists, err
+	}
+	if response.IsError() {
+		return nil, false, fmt.Errorf("%s", response)
+	}
+
+	var aliases map[string]json.RawMessage
+	if err := json.NewDecoder(response.Body).Decode(&aliases); err != nil {
+		return nil, false, err
+	}
+
+	targets := make([]string, 0, len(aliases))
+	for index := range aliases {
+		targets = append(targets, index)
+	}
+	sort.Strings(targets)
+
+	return targets, false, nil
+}
+
+// atomically points the alias to the index, detaching it from all previous targets
+func switchAlias(client *elasticsearch.Client, alias, index string) error {
+	previous, isConcreteIndex, err := getAliasTargets(client, alias)
+	if err != nil {
+		return err
+	}
+
+	actions := []map[string]map[string]string{
+		{"add": {"index": index, "alias": alias}},
+	}
+	for _, target := range previous {
+		if target != index {
+			actions = append(actions, map[string]map[string]string{"remove": {"index": target, "alias": alias}})
+		}
+	}
+
+	// index created before aliases were introduced has to go in the same request
+	if isConcreteIndex {
+		actions = append(actions, map[string]map[string]string{"remove_index": {"index": alias}})
+	}
+
+	marshalizedActions, err := json.Marshal(map[string]any{"actions": actions})
+	if err != nil {
+		return err
+	}
+
+	response, err := client.Indices.UpdateAliases(strings.NewReader(string(marshalizedActions)))
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.IsError() {
+		return fmt.Errorf("%s", response)
+	}
+	log.Printf("Alias \"%s\" now points to \"%s\"\n", alias, index)
+
+	return nil
+}
+
+func countDocuments(client *elasticsearch.Client, index string) (int, error) {
+	response, err := client.Indices.Refresh(client.Indices.Refresh.WithIndex(index))
+	if err != nil {
+		return 0, err
+	}
+	response.Body.Close()
+	if response.IsError() {
+		return 0, fmt.Errorf("%s", response)
+	}
+
+	response, err = client.Count(client.Count.WithIndex(index))
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+
+	if response.IsError() {
+		return 0, fmt.Errorf("%s", response)
+	}
+
+	var result struct {
+		Count int `json:"count"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&result); err != nil {
+		return 0, err
+	}
+
+	return result.Count, nil
+}
+
+// deletes generations older than the current one, keeping at most keep of them
+func pruneGenerations(client *elasticsearch.Client, alias, current string, keep int) error {
+	generations, err := listGenerations(client, alias)
+	if err != nil {
+		return err
+	}
+
+	older := make([]string, 0, len(generations))
+	for _, generation := range generations {
+		if generation < current {
+			older = append(older, generation)
+		}
+	}
+
+	if len(older) <= keep {
+		return nil
+	}
+
+	return deleteIndices(client, older[:len(older)-keep]...)
+}
+
+// points the alias back to the generation preceding the current one
+func rollback(client *elasticsearch.Client, alias string) error {
+	targets, _, err := getAliasTargets(client, alias)
+	if err != nil {
+		return err
+	}
+	if len(targets) != 1 {
+		return fmt.Errorf("alias \"%s\" must point to exactly one index, got %q", alias, targets)
+	}
+
+	generations, err := listGenerations(client, alias)
+	if err != nil {
+		return err
+	}
+
+	previous := ""
+	for _, generation := range generations {
+		if generation < targets[0] {
+			previous = generation
+		}
+	}
+	if previous == "" {
+		return fmt.Errorf("there is no generation of \"%s\" older than \"%s\"", alias, targets[0])
+	}
+
+	return switchAlias(client, alias, previous)
+}
+
+type scrollResponse struct {
+	ScrollID string `json:"_scroll_id"`
+	Hits     struct {
+		Hits []struct {
+			ID     string          `json:"_id"`
+			Source json.RawMessage `json:"_source"`
+		} `json:"hits"`
+	} `json:"hits"`
+}
+
+// visits every document of the index, fetching only the fields if any are given
+func scrollDocuments(client *elasticsearch.Client, index string, fields []string, visit func(id string, source json.RawMessage) error) error {
+	options := []func(*esapi.SearchRequest){
+		client.Search.WithIndex(index),
+		client.Search.WithScroll(time.Minute),
+		client.Search.WithSize(10_000),
+	}
+	if len(fields) > 0 {
+		options = append(options, client.Search.WithSource(fields...))
+	}
+	response, err := client.Search(options...)
+
+	for {
+		if err != nil {
+			return err
+		}
+		if response.IsError() {
+			response.Body.Close()
+			return fmt.Errorf("%s", response)
+		}
+
+		var result scrollResponse
+		err = json.NewDecoder(response.Body).Decode(&result)
+		response.Body.Close()
+		if err != nil {
+			return err
+		}
+
+		// no more data to fetch
+		if len(result.Hits.Hits) == 0 {
+			clearResponse, err := client.ClearScroll(client.ClearScroll.WithBody(
+				strings.NewReader(fmt.Sprintf(`{"scroll_id": %q}`, result.ScrollID)),
+			))
+			if err == nil {
+				clearResponse.Body.Close()
+			}
+			return nil
+		}
+
+		for _, hit := range result.Hits.Hits {
+			if err := visit(hit.ID, hit.Source); err != nil {
+				return err
+			}
+		}
+
+		response, err = client.Scroll(
+			client.Scroll.WithScrollID(result.ScrollID),
+			client.Scroll.WithScroll(time.Minute),
+		)
+	}
+}
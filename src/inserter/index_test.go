@@ -0,0 +1,121 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8"
+)
+
+func TestIsGeneration(t *testing.T) {
+	tests := []struct {
+		index string
+		want  bool
+	}{
+		{index: "places-20261019T153012", want: true},
+		{index: "places-20261019T1530", want: true},
+		{index: "places-2026", want: false},
+		{index: "places", want: false},
+		{index: "reviews-20261019T153012", want: false},
+		{index: "places-backup", want: false},
+	}
+
+	for _, test := range tests {
+		if got := isGeneration("places", test.index); got != test.want {
+			t.Errorf("isGeneration(%q) = %v, want %v", test.index, got, test.want)
+		}
+	}
+}
+
+func TestGenerationsSortChronologically(t *testing.T) {
+	moment := time.Date(2026, 10, 19, 15, 30, 0, 0, time.UTC)
+	legacy := "places-" + moment.Format(legacyGenerationLayout)
+	later := generationName("places", moment.Add(5*time.Second))
+	nextMinute := "places-" + moment.Add(time.Minute).Format(legacyGenerationLayout)
+
+	if !(legacy < later && later < nextMinute) {
+		t.Errorf("generations %q, %q and %q don't sort chronologically", legacy, later, nextMinute)
+	}
+}
+
+func TestCreateGenerationSkipsExistingNames(t *testing.T) {
+	existing := map[string]bool{}
+	var created []string
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Elastic-Product", "Elasticsearch")
+		w.Header().Set("Content-Type", "application/json")
+
+		index := strings.TrimPrefix(r.URL.Path, "/")
+		if r.Method != http.MethodPut {
+			http.NotFound(w, r)
+			return
+		}
+
+		// the first two seconds are taken by earlier generations
+		if len(existing) < 2 || existing[index] {
+			existing[index] = true
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error": {"type": "resource_already_exists_exception"}, "status": 400}`))
+			return
+		}
+
+		created = append(created, index)
+		w.Write([]byte(`{"acknowledged": true}`))
+	}))
+	defer server.Close()
+
+	client, err := elasticsearch.NewClient(elasticsearch.Config{Addresses: []string{server.URL}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	index, err := createGeneration(client, "places", `{}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(created) != 1 || created[0] != index || existing[index] || !isGeneration("places", index) {
+		t.Errorf("created %q as %q, while %v exist", created, index, existing)
+	}
+}
+
+func TestIndexExists(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Elastic-Product", "Elasticsearch")
+
+		switch strings.TrimPrefix(r.URL.Path, "/") {
+		case "places-20261019T153012":
+			w.WriteHeader(http.StatusOK)
+		case "broken":
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	client, err := elasticsearch.NewClient(elasticsearch.Config{Addresses: []string{server.URL}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		index   string
+		exists  bool
+		wantErr bool
+	}{
+		{index: "places-20261019T153012", exists: true},
+		{index: "places-20261019T160000", exists: false},
+		{index: "broken", wantErr: true},
+	}
+
+	for _, test := range tests {
+		exists, err := indexExists(client, test.index)
+		if exists != test.exists || (err != nil) != test.wantErr {
+			t.Errorf("indexExists(%q) = %v, %v, want %v and error %v", test.index, exists, err, test.exists, test.wantErr)
+		}
+	}
+}
@@ -3,98 +3,263 @@ package main
 import (
 	"args"
 	"bytes"
-	"common"
 	"context"
 	"db"
-	"encoding/csv"
 	"encoding/json"
 	"fmt"
 	"io"
 	"log"
+	"net/http"
 	"os"
-	"strconv"
-	"strings"
+	"runtime"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/elastic/go-elasticsearch/v8"
-	"github.com/elastic/go-elasticsearch/v8/esapi"
 	"github.com/elastic/go-elasticsearch/v8/esutil"
 )
 
-const mapping = `
-{
-	"mappings": {
-		"properties": {
-			"name": {
-				"type": "text"
-			},
-			"address": {
-				"type": "text"
-			},
-			"phone": {
-				"type": "text"
-			},
-			"location": {
-				"type": "geo_point"
-			}
+// callbacks are called once elasticsearch has acknowledged or rejected the item
+func addItem(
+	indexer *esutil.BulkIndexer,
+	item esutil.BulkIndexerItem,
+	onSuccess func(),
+	onFailure func(reason string),
+) error {
+	item.OnSuccess = func(context.Context, esutil.BulkIndexerItem, esutil.BulkIndexerResponseItem) {
+		onSuccess()
+	}
+	item.OnFailure = func(_ context.Context, _ esutil.BulkIndexerItem, response esutil.BulkIndexerResponseItem, err error) {
+		onFailure(bulkFailureReason(response, err))
+	}
+
+	return (*indexer).Add(context.Background(), item)
+}
+
+func insertRecord(
+	indexer *esutil.BulkIndexer,
+	marshalizedRecord []byte,
+	id string,
+	onSuccess func(),
+	onFailure func(reason string),
+) error {
+	return addItem(
+		indexer,
+		esutil.BulkIndexerItem{
+			Action:     "index",
+			DocumentID: id,
+			Body:       bytes.NewReader(marshalizedRecord),
+		},
+		onSuccess,
+		onFailure,
+	)
+}
+
+func deleteRecord(indexer *esutil.BulkIndexer, id string, onSuccess func(), onFailure func(reason string)) error {
+	return addItem(
+		indexer,
+		esutil.BulkIndexerItem{
+			Action:     "delete",
+			DocumentID: id,
+		},
+		onSuccess,
+		onFailure,
+	)
+}
+
+type importSummary struct {
+	Readed    uint64 `json:"read"`
+	Inserted  uint64 `json:"inserted"`
+	Updated   uint64 `json:"updated"`
+	Unchanged uint64 `json:"unchanged"`
+	Deleted   uint64 `json:"deleted"`
+	Duplicate uint64 `json:"duplicate"`
+	Merged    uint64 `json:"merged"` // skipped, since the dedup command has merged them into other places
+	Rejected  uint64 `json:"rejected"`
+}
+
+// counters are updated concurrently by workers and indexer callbacks
+func (summary *importSummary) load() importSummary {
+	return importSummary{
+		Readed:    atomic.LoadUint64(&summary.Readed),
+		Inserted:  atomic.LoadUint64(&summary.Inserted),
+		Updated:   atomic.LoadUint64(&summary.Updated),
+		Unchanged: atomic.LoadUint64(&summary.Unchanged),
+		Deleted:   atomic.LoadUint64(&summary.Deleted),
+		Duplicate: atomic.LoadUint64(&summary.Duplicate),
+		Merged:    atomic.LoadUint64(&summary.Merged),
+		Rejected:  atomic.LoadUint64(&summary.Rejected),
+	}
+}
+
+type bulkReport struct {
+	Indexed         uint64 `json:"indexed"`
+	Failed          uint64 `json:"failed"`
+	Retried         uint64 `json:"retried"`
+	FlushedRequests uint64 `json:"flushed_requests"`
+}
+
+// machine-readable summary printed to stdout once the import is over
+type importReport struct {
+	Index           string           `json:"index"`
+	Mode            string           `json:"mode"`
+	ResumedRecords  uint64           `json:"resumed_records,omitempty"`
+	DurationSeconds float64          `json:"duration_seconds"`
+	Records         importSummary    `json:"records"`
+	Bulk            bulkReport       `json:"bulk"`
+	Validation      validationReport `json:"validation"`
+	DeadLetter      string           `json:"dead_letter,omitempty"`
+}
+
+// record read from the data file, waiting to be parsed and indexed
+type rawRecord struct {
+	sequence uint64 // number of the record in the data file starting from 1
+	line     int
+	id       uint64
+	fields   []string
+}
+
+// stages a record passes through on its way from the data file to the index
+type pipeline struct {
+	indexer     *esutil.BulkIndexer
+	workers     int
+	schema      *schema
+	validator   *validator
+	existing    *existingDocuments // nil unless records are upserted
+	previous    *existingDocuments // documents replaced by a new generation to keep their timestamps and ratings
+	started     time.Time          // timestamp of new and updated documents
+	deadLetters *deadLetter
+	dryRun      *dryRunReport // collects documents instead of the indexer during a dry run
+	checkpoints *checkpointTracker
+	skip        uint64 // number of leading records done before the import was resumed
+	summary     importSummary
+}
+
+// documents of the index being upserted or replaced, nil if there are none
+func (p *pipeline) known() *existingDocuments {
+	if p.existing != nil {
+		return p.existing
+	}
+	return p.previous
+}
+
+func (p *pipeline) insertRawRecord(mapping *columnMapping, record rawRecord) {
+	reject := func(reason string) {
+		p.deadLetters.write(record.line, record.fields, reason)
+		p.checkpoints.finish(record.sequence, record.line, false)
+	}
+
+	id := fmt.Sprint(record.id)
+	if known := p.known(); known != nil {
+		if _, merged := known.mergedInto(id); merged {
+			atomic.AddUint64(&p.summary.Merged, 1)
+			p.checkpoints.finish(record.sequence, record.line, false)
+			return
 		}
 	}
-}`
 
-func recreateIndex(client *elasticsearch.Client, index, mapping string) (*esapi.Response, error) {
-	response, err := client.Indices.Delete([]string{index}, client.Indices.Delete.WithIgnoreUnavailable(true))
+	// a row rejected below keeps its document rather than having it deleted as missing,
+	// so the document is marked as present before the row is parsed
+	var existing existingDocument
+	var exists bool
+	if p.existing != nil {
+		existing, exists = p.existing.visit(id)
+	}
+
+	place, err := mapping.place(record.id, record.fields)
 	if err != nil {
-		return nil, fmt.Errorf("can't delete index \"%s\", skipping", index)
+		reject(parseFailureReason(err))
+		return
 	}
-	if response.IsError() {
-		return response, nil
+
+	if err := p.validator.validate(&place, record.line); err != nil {
+		reject(fmt.Sprintf("validation: %s", err))
+		return
 	}
 
-	log.Printf("Deleted previously created index \"%s\"", index)
-	response.Body.Close()
+	hash, err := contentHash(place)
+	if err != nil {
+		reject(parseFailureReason(err))
+		return
+	}
 
-	response, err = client.Indices.Create(
-		index,
-		client.Indices.Create.WithBody(strings.NewReader(mapping)),
-	)
+	counter := &p.summary.Inserted
+	var previous *existingDocument
+	if p.existing != nil {
+		if exists && existing.ContentHash == hash {
+			atomic.AddUint64(&p.summary.Unchanged, 1)
+			p.checkpoints.finish(record.sequence, record.line, false)
+			return
+		}
+		if exists {
+			counter = &p.summary.Updated
+			previous = &existing
+		}
+	} else if p.previous != nil {
+		previous = p.previous.lookup(id)
+	}
+	stampPlace(&place, previous, hash, p.started)
+
+	marshalizedRecord, err := marshalDocument(place, hash)
 	if err != nil {
-		return nil, err
+		reject(parseFailureReason(err))
+		return
 	}
-	if response.IsError() {
-		return response, nil
+
+	if p.dryRun != nil {
+		p.dryRun.add(marshalizedRecord)
+		atomic.AddUint64(&p.summary.Inserted, 1)
+		return
+	}
+
+	acknowledge := func() {
+		atomic.AddUint64(counter, 1)
+		p.checkpoints.finish(record.sequence, record.line, true)
 	}
-	response.Body.Close()
-	log.Printf("Created index \"%s\"\n", index)
 
-	return nil, nil
+	if err := insertRecord(p.indexer, marshalizedRecord, id, acknowledge, reject); err != nil {
+		reject(fmt.Sprintf("bulk: %s", err))
+	}
 }
 
-func insertRecord(indexer *esutil.BulkIndexer, record common.Place, id uint64) error {
-	marshalizedRecord, err := json.Marshal(record)
+// Records are parsed by a fixed number of workers. The channel between the
+// reader and the workers is as large as the indexer queue, so once the indexer
+// is busy flushing, both the workers and the reader block on it.
+func (p *pipeline) readAndInsertRecords(reader recordReader) {
+	header, err := reader.Header()
+	if err == io.EOF {
+		return
+	}
 	if err != nil {
-		return err
+		log.Fatalln(err)
 	}
+	p.deadLetters.setHeader(header)
 
-	err = (*indexer).Add(
-		context.Background(),
-		esutil.BulkIndexerItem{
-			Action:     "index",
-			DocumentID: fmt.Sprint(id),
-			Body:       bytes.NewReader(marshalizedRecord),
-		},
-	)
+	mapping, err := p.schema.resolve(header)
+	if err != nil {
+		log.Fatalf("Can't map columns of the data file: %s", err)
+	}
 
-	return err
-}
+	records := make(chan rawRecord, p.workers)
+	waitGroup := sync.WaitGroup{}
+	for i := 0; i < p.workers; i++ {
+		waitGroup.Add(1)
+
+		go func() {
+			defer waitGroup.Done()
 
-func readAndInsertRecords(indexer *esutil.BulkIndexer, csvReader *csv.Reader) (readedRecords uint64, succesfullyInsertedRecords uint64) {
-	succesfullyInsertedRecords, readedRecords = 0, 0
+			for record := range records {
+				p.insertRawRecord(mapping, record)
+			}
+		}()
+	}
+
+	// maps ids to the lines they were first seen on
+	seenIds := make(map[uint64]int)
 
-	isHeaderReaded := false
-	waitGroup := sync.WaitGroup{}
 	for {
-		record, err := csvReader.Read()
+		record, line, err := reader.Read()
 		if err == io.EOF {
 			break
 		}
@@ -102,136 +267,624 @@ func readAndInsertRecords(indexer *esutil.BulkIndexer, csvReader *csv.Reader) (r
 			log.Fatalln(err)
 		}
 
-		// skip header
-		if !isHeaderReaded {
-			isHeaderReaded = true
-			continue
-		}
+		sequence := atomic.AddUint64(&p.summary.Readed, 1)
 
-		readedRecords++
-		waitGroup.Add(1)
-
-		// asynchronously add records to the index
-		go func(currentId uint64) {
-			defer waitGroup.Done()
+		// records done before the import was resumed are only needed to detect duplicates
+		isDone := sequence <= p.skip
 
-			errorMessage := "Couldn't insert record"
-
-			lon, err := strconv.ParseFloat(record[4], 64)
-			if err != nil {
-				log.Printf("%s: %s\n", errorMessage, err)
-				return
+		currentId, err := mapping.ids.generate(record)
+		if err != nil {
+			if !isDone {
+				p.deadLetters.write(line, record, parseFailureReason(err))
+				p.checkpoints.finish(sequence, line, false)
 			}
-
-			lat, err := strconv.ParseFloat(record[5], 64)
-			if err != nil {
-				log.Printf("%s: %s\n", errorMessage, err)
-				return
+			continue
+		}
+		if firstLine, exists := seenIds[currentId]; exists {
+			if !isDone {
+				p.deadLetters.write(line, record, fmt.Sprintf("duplicate: id %d was first seen on line %d", currentId, firstLine))
+				p.checkpoints.finish(sequence, line, false)
+				atomic.AddUint64(&p.summary.Duplicate, 1)
 			}
+			continue
+		}
+		seenIds[currentId] = line
 
-			err = insertRecord(
-				indexer,
-
-				common.Place{
-					ID:      currentId,
-					Name:    record[1],
-					Address: record[2],
-					Phone:   record[3],
-					Location: common.Location{
-						Longitude: lon,
-						Latitude:  lat,
-					},
-				},
-
-				currentId,
-			)
-			if err != nil {
-				log.Printf("%s: %s\n", errorMessage, err)
-				return
+		if isDone {
+			// keeps documents from being deleted as missing
+			if p.existing != nil {
+				p.existing.visit(fmt.Sprint(currentId))
+				p.existing.mergedInto(fmt.Sprint(currentId))
 			}
-			atomic.AddUint64(&succesfullyInsertedRecords, 1)
-		}(readedRecords)
+			continue
+		}
+
+		records <- rawRecord{sequence: sequence, line: line, id: currentId, fields: record}
 	}
 
+	close(records)
 	waitGroup.Wait()
+}
+
+// Reads and indexes records, deleting missing ones if asked. Indexer workers run
+// callbacks of the last flush, which count acknowledged documents, while it's
+// being closed, so the summary is complete only after the indexer is closed.
+func (p *pipeline) run(reader recordReader, deleteMissing bool) (importSummary, error) {
+	p.readAndInsertRecords(reader)
+	if deleteMissing {
+		p.deleteMissingRecords()
+	}
+
+	if err := (*p.indexer).Close(context.Background()); err != nil {
+		return importSummary{}, err
+	}
+	return p.summary.load(), nil
+}
 
-	return readedRecords, succesfullyInsertedRecords
+func (p *pipeline) deleteMissingRecords() {
+	for _, id := range p.existing.missing() {
+		onSuccess := func() {
+			atomic.AddUint64(&p.summary.Deleted, 1)
+		}
+		onFailure := func(reason string) {
+			log.Printf("Couldn't delete record \"%s\": %s\n", id, reason)
+		}
+
+		if err := deleteRecord(p.indexer, id, onSuccess, onFailure); err != nil {
+			onFailure(err.Error())
+		}
+	}
 }
 
 func getDefaultFlags() []args.Arg {
 	return []args.Arg{
 		{
 			Name:         "cacert",
-			Description:  "Path to http_ca.crt file",
+			Description:  "Path to http_ca.crt file, required unless it's a dry run",
+			DefaultValue: "",
+			Required:     false,
+		},
+		{
+			Name:         "index",
+			Description:  "Name of the alias the api reads from, index generations are named after it",
+			DefaultValue: "places",
+			Required:     false,
+		},
+		{
+			Name:         "mapping-file",
+			Description:  "Path to JSON file with settings and mappings of the index, defaults to the latest migration",
 			DefaultValue: "",
-			Required:     true,
+			Required:     false,
+		},
+		{
+			Name:         "shards",
+			Description:  "Number of primary shards of a new index generation, 0 keeps the value of the mapping file",
+			DefaultValue: 0,
+			Required:     false,
+		},
+		{
+			Name:         "replicas",
+			Description:  "Number of replicas of a new index generation, negative keeps the value of the mapping file",
+			DefaultValue: -1,
+			Required:     false,
+		},
+		{
+			Name:         "bulk-refresh-interval",
+			Description:  "Refresh interval of the index while records are loaded, restored afterwards; empty leaves it untouched",
+			DefaultValue: "-1",
+			Required:     false,
 		},
 		{
 			Name:         "data",
-			Description:  "Path to data CSV file",
+			Description:  "Path to data file, possibly gzip, zstd or zip compressed, \"-\" reads stdin; required by the import command",
+			DefaultValue: "",
+			Required:     false,
+		},
+		{
+			Name:         "keep-generations",
+			Description:  "Number of previous index generations to keep after the import, 0 keeps all of them",
+			DefaultValue: 0,
+			Required:     false,
+		},
+		{
+			Name:         "mode",
+			Description:  "Import mode: upsert, replace or append",
+			DefaultValue: modeReplace,
+			Required:     false,
+		},
+		{
+			Name:         "format",
+			Description:  "Format of data file: tsv, csv, json, ndjson or geojson; detected by extension if empty",
+			DefaultValue: "",
+			Required:     false,
+		},
+		{
+			Name:         "schema",
+			Description:  "Path to JSON file mapping columns of the data file to place fields, defaults to the layout of data.csv; JSON formats need columns referred to by key",
 			DefaultValue: "",
-			Required:     true,
+			Required:     false,
+		},
+		{
+			Name:         "phone-country-code",
+			Description:  "Country calling code for phones written without one",
+			DefaultValue: "7",
+			Required:     false,
+		},
+		{
+			Name:         "bbox",
+			Description:  "Reject places outside of \"minLon,minLat,maxLon,maxLat\" box, e.g. \"36.8,55.1,38.0,56.1\" for Moscow",
+			DefaultValue: "",
+			Required:     false,
+		},
+		{
+			Name:         "dead-letter",
+			Description:  "Path to TSV file for rejected records, which can be imported again later; empty disables it",
+			DefaultValue: "dead_letter.tsv",
+			Required:     false,
+		},
+		{
+			Name:         "workers",
+			Description:  "Number of workers parsing records and flushing them to elasticsearch",
+			DefaultValue: runtime.NumCPU(),
+			Required:     false,
+		},
+		{
+			Name:         "flush-bytes",
+			Description:  "Size of a bulk request in bytes, records are read no faster than they are flushed",
+			DefaultValue: 5_000_000,
+			Required:     false,
+		},
+		{
+			Name:         "dry-run",
+			Description:  "Parse and validate the data file, print what would be indexed without touching elasticsearch",
+			DefaultValue: false,
+			Required:     false,
+		},
+		{
+			Name:         "samples",
+			Description:  "Number of sample documents printed by the dry run",
+			DefaultValue: 3,
+			Required:     false,
+		},
+		{
+			Name:         "checkpoint",
+			Description:  "Path to file the progress of the import is saved to after every bulk request",
+			DefaultValue: "inserter.checkpoint.json",
+			Required:     false,
+		},
+		{
+			Name:         "resume",
+			Description:  "Continue the interrupted import from its checkpoint",
+			DefaultValue: false,
+			Required:     false,
+		},
+		{
+			Name:         "progress-interval",
+			Description:  "Seconds between progress reports, 0 disables them",
+			DefaultValue: 5,
+			Required:     false,
+		},
+		{
+			Name:         "max-failures",
+			Description:  "Number of rejected records above which the import fails, 0 makes any rejected record fail it, negative allows any number",
+			DefaultValue: -1,
+			Required:     false,
+		},
+		{
+			Name:         "delete-missing",
+			Description:  "Delete documents which are absent in the data file, upsert mode only",
+			DefaultValue: false,
+			Required:     false,
+		},
+		{
+			Name:         "dedup-distance",
+			Description:  "Meters between places with similar names above which the dedup command doesn't consider them duplicates",
+			DefaultValue: 100.0,
+			Required:     false,
+		},
+		{
+			Name:         "dedup-similarity",
+			Description:  "Similarity of normalized names from 0 to 1 starting from which the dedup command considers nearby places duplicates",
+			DefaultValue: 0.85,
+			Required:     false,
+		},
+		{
+			Name:         "dedup-report",
+			Description:  "Path to JSON file the dedup command writes clusters of duplicates to",
+			DefaultValue: "dedup_report.json",
+			Required:     false,
+		},
+		{
+			Name:         "merge",
+			Description:  "Merge duplicates found by the dedup command into canonical places instead of only reporting them",
+			DefaultValue: false,
+			Required:     false,
+		},
+		{
+			Name:         "reviews-index",
+			Description:  "Name of the index reviews are stored in, reviews of merged duplicates are moved to their canonical places",
+			DefaultValue: "reviews",
+			Required:     false,
 		},
 	}
 }
 
-func main() {
-	log.SetFlags(log.Lshortfile)
-
-	// acquiring command-line arguments data
-	parsedArgs, _, err := args.ParseArgs(getDefaultFlags()...)
+// loads the mapping file and applies settings overridden by flags
+func prepareIndexDefinition(parsedArgs map[string]any) indexDefinition {
+	definition, err := loadIndexDefinition(parsedArgs["mapping-file"].(string))
 	if err != nil {
 		log.Fatalln(err)
 	}
 
-	// reading certificate
-	CACert, err := os.ReadFile(parsedArgs["cacert"].(string))
+	overrideSettings(definition, parsedArgs)
+	return definition
+}
+
+func overrideSettings(definition indexDefinition, parsedArgs map[string]any) {
+	if shards := parsedArgs["shards"].(int); shards > 0 {
+		definition.setSetting("number_of_shards", shards)
+	}
+	if replicas := parsedArgs["replicas"].(int); replicas >= 0 {
+		definition.setSetting("number_of_replicas", replicas)
+	}
+}
+
+// opens the data file and sets up stages shared by the import and the dry run
+func preparePipeline(parsedArgs map[string]any) (*pipeline, recordReader, *dataInput) {
+	dataPath := parsedArgs["data"].(string)
+	if dataPath == "" {
+		log.Fatalln("flag \"data\" is required by the import command")
+	}
+
+	recordSchema, err := loadSchema(parsedArgs["schema"].(string))
 	if err != nil {
 		log.Fatalln(err)
 	}
 
-	// creating CSV reader
-	restaurantsFile, err := os.Open(parsedArgs["data"].(string))
+	box, err := parseBoundingBox(parsedArgs["bbox"].(string))
 	if err != nil {
-		log.Fatalln()
+		log.Fatalln(err)
 	}
-	defer restaurantsFile.Close()
 
-	csvReader := csv.NewReader(restaurantsFile)
-	csvReader.Comma = '\t'
-	csvReader.FieldsPerRecord = -1
-	csvReader.TrimLeadingSpace = true
+	workers := parsedArgs["workers"].(int)
+	if workers <= 0 {
+		log.Fatalln("number of workers must be positive")
+	}
 
-	client, err := db.CreateClient(CACert)
+	// creating records reader
+	restaurantsFile, err := openData(dataPath)
 	if err != nil {
 		log.Fatalln(err)
 	}
-	log.Println("Created elasticsearch client")
 
-	response, err := recreateIndex(client, "places", mapping)
+	reader, err := newRecordReader(restaurantsFile, restaurantsFile.name, parsedArgs["format"].(string), recordSchema)
 	if err != nil {
-		log.Fatalf("Can't create index \"places\": %s", err)
-	} else if response != nil && response.IsError() {
-		log.Fatalf("Can't create index \"places\": %s", response)
+		log.Fatalln(err)
+	}
+
+	return &pipeline{
+		started:   time.Now(),
+		workers:   workers,
+		schema:    recordSchema,
+		validator: newValidator(parsedArgs["phone-country-code"].(string), box),
+	}, reader, restaurantsFile
+}
+
+func importRecords(client *elasticsearch.Client, parsedArgs map[string]any) {
+	mode := parsedArgs["mode"].(string)
+	if mode != modeReplace && mode != modeAppend && mode != modeUpsert {
+		log.Fatalf("unknown mode \"%s\", expected one of %q", mode, []string{modeUpsert, modeReplace, modeAppend})
+	}
+
+	deleteMissing := parsedArgs["delete-missing"].(bool)
+	if deleteMissing && mode != modeUpsert {
+		log.Fatalf("flag \"delete-missing\" is supported only in \"%s\" mode", modeUpsert)
 	}
 
+	started := time.Now()
+
+	importPipeline, reader, input := preparePipeline(parsedArgs)
+	defer input.Close()
+
+	alias := parsedArgs["index"].(string)
+	definition := prepareIndexDefinition(parsedArgs)
+
+	dataPath := parsedArgs["data"].(string)
+	checkpointPath := parsedArgs["checkpoint"].(string)
+	previous := checkpoint{Data: dataPath, Mode: mode}
+	resume := parsedArgs["resume"].(bool)
+	if resume {
+		loaded, err := loadCheckpoint(checkpointPath)
+		if err != nil {
+			log.Fatalf("Can't resume the import: %s", err)
+		}
+		if loaded.Data != dataPath || loaded.Mode != mode {
+			log.Fatalf(
+				"Checkpoint \"%s\" belongs to import of \"%s\" in \"%s\" mode",
+				checkpointPath,
+				loaded.Data,
+				loaded.Mode,
+			)
+		}
+
+		if mode == modeReplace && !isGeneration(alias, loaded.Index) {
+			log.Fatalf("Checkpoint \"%s\" belongs to import into \"%s\" instead of \"%s\"", checkpointPath, loaded.Index, alias)
+		}
+
+		previous = *loaded
+		log.Printf("Resuming import after line %d, %d records are already done\n", previous.Line, previous.Records)
+	} else if _, err := os.Stat(checkpointPath); err == nil {
+		log.Printf("Found checkpoint \"%s\" of an interrupted import, starting over since --resume is not set\n", checkpointPath)
+	}
+
+	var index string
+	var existing, replaced *existingDocuments
+	if mode == modeReplace {
+		targets, isConcreteIndex, err := getAliasTargets(client, alias)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		if len(targets) > 0 || isConcreteIndex {
+			if replaced, err = loadExistingDocuments(client, alias); err != nil {
+				log.Fatalln(err)
+			}
+			log.Printf("Loaded %d documents of the current generation to keep their timestamps and ratings\n", replaced.count())
+		}
+	}
+
+	if mode == modeReplace && resume {
+		// continuing to fill the generation the interrupted import has created,
+		// unless it was deleted since, e.g. by a failed import or pruning
+		exists, err := indexExists(client, previous.Index)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		if !exists {
+			log.Fatalf("Can't resume the import: index \"%s\" of checkpoint \"%s\" doesn't exist, start over without --resume", previous.Index, checkpointPath)
+		}
+		index = previous.Index
+	} else if mode == modeReplace {
+		// writing into a fresh generation so the alias keeps serving the previous one
+		var err error
+		if index, err = createGeneration(client, alias, definition.String()); err != nil {
+			log.Fatalln(err)
+		}
+	} else {
+		targets, isConcreteIndex, err := getAliasTargets(client, alias)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		if len(targets) == 0 && !isConcreteIndex {
+			log.Fatalf("Index \"%s\" doesn't exist, run import in \"%s\" mode first", alias, modeReplace)
+		}
+
+		index = alias
+		if mode == modeUpsert {
+			existing, err = loadExistingDocuments(client, index)
+			if err != nil {
+				log.Fatalln(err)
+			}
+			log.Printf("Loaded %d existing documents\n", existing.count())
+		}
+	}
+
+	// refreshing during the bulk load only slows it down
+	bulkRefreshInterval := parsedArgs["bulk-refresh-interval"].(string)
+	refreshInterval := definition.setting("refresh_interval")
+	if bulkRefreshInterval != "" {
+		if mode != modeReplace {
+			// the live index may be tuned differently, unless an interrupted import has left it in bulk mode
+			current, err := getRefreshInterval(client, index)
+			if err != nil {
+				log.Fatalln(err)
+			}
+			if current != bulkRefreshInterval {
+				refreshInterval = current
+			}
+		}
+
+		if err := setRefreshInterval(client, index, bulkRefreshInterval); err != nil {
+			log.Fatalln(err)
+		}
+	}
+
+	previous.Index = index
+	checkpoints := newCheckpointTracker(checkpointPath, previous)
+
+	deadLetters := newDeadLetter(parsedArgs["dead-letter"].(string))
+	deadLetters.appendRecords = resume
+
 	// creating indexer
 	bulkIndexer, err := esutil.NewBulkIndexer(esutil.BulkIndexerConfig{
-		Index:  "places",
-		Client: client,
+		Index:      index,
+		Client:     client,
+		NumWorkers: importPipeline.workers,
+		FlushBytes: parsedArgs["flush-bytes"].(int),
+		OnFlushEnd: func(context.Context) {
+			if err := deadLetters.sync(); err != nil {
+				log.Printf("Couldn't save checkpoint, dead-letter file isn't written: %s\n", err)
+				return
+			}
+			if err := checkpoints.save(); err != nil {
+				log.Printf("Couldn't save checkpoint: %s\n", err)
+			}
+		},
 	})
 	if err != nil {
 		log.Fatalln(err)
 	}
 
-	readedRecords, succesfullyInsertedRecords := readAndInsertRecords(&bulkIndexer, csvReader)
+	importPipeline.indexer = &bulkIndexer
+	importPipeline.existing = existing
+	importPipeline.previous = replaced
+	importPipeline.deadLetters = deadLetters
+	importPipeline.checkpoints = checkpoints
+	importPipeline.skip = previous.Records
+
+	var progress *progressReporter
+	if interval := parsedArgs["progress-interval"].(int); interval > 0 {
+		progress = startProgress(time.Duration(interval)*time.Second, importPipeline, input)
+	}
 
-	if err := bulkIndexer.Close(context.Background()); err != nil {
+	summary, err := importPipeline.run(reader, deleteMissing)
+	if err != nil {
 		log.Fatalln(err)
 	}
+	if progress != nil {
+		progress.Stop()
+	}
 
-	fmt.Printf("Succesfully readed records: %d\nSuccesfully inserted records: %d\n",
-		readedRecords,
-		succesfullyInsertedRecords,
-	)
+	if bulkRefreshInterval != "" {
+		if err := setRefreshInterval(client, index, refreshInterval); err != nil {
+			log.Println(err)
+		}
+	}
+
+	// every record is done with, either way there is nothing to resume
+	if err := checkpoints.remove(); err != nil {
+		log.Println(err)
+	}
+
+	// all failure callbacks have run once the indexer is closed
+	if err := deadLetters.Close(); err != nil {
+		log.Println(err)
+	}
+
+	summary.Rejected = deadLetters.rejected()
+	stats := bulkIndexer.Stats()
+	report := importReport{
+		Index:           index,
+		Mode:            mode,
+		ResumedRecords:  previous.Records,
+		DurationSeconds: time.Since(started).Seconds(),
+		Records:         summary,
+		Bulk: bulkReport{
+			Indexed:         stats.NumIndexed,
+			Failed:          stats.NumFailed,
+			Retried:         atomic.LoadUint64(&retriedRequests),
+			FlushedRequests: stats.NumRequests,
+		},
+		Validation: importPipeline.validator.Report(),
+	}
+	if summary.Rejected > 0 {
+		report.DeadLetter = deadLetters.path
+	}
+
+	marshalizedReport, _ := json.MarshalIndent(report, "", "  ")
+	fmt.Println(string(marshalizedReport))
+
+	var failure error
+	maxFailures := parsedArgs["max-failures"].(int)
+	if maxFailures >= 0 && deadLetters.rejected() > uint64(maxFailures) {
+		failure = fmt.Errorf("%d records were rejected, at most %d are allowed", deadLetters.rejected(), maxFailures)
+	}
+
+	if mode != modeReplace {
+		if failure != nil {
+			log.Fatalln(failure)
+		}
+		return
+	}
+
+	if failure == nil {
+		count, err := countDocuments(client, index)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		// documents indexed before the import was resumed are in the index too
+		expected := summary.Inserted + previous.Inserted
+		if count == 0 || uint64(count) != expected {
+			failure = fmt.Errorf("index \"%s\" contains %d documents instead of %d", index, count, expected)
+		}
+	}
+
+	if failure != nil {
+		if err := deleteIndices(client, index); err != nil {
+			log.Println(err)
+		}
+		log.Fatalf("%s, alias \"%s\" is left untouched", failure, alias)
+	}
+
+	if err := switchAlias(client, alias, index); err != nil {
+		log.Fatalln(err)
+	}
+
+	if keep := parsedArgs["keep-generations"].(int); keep > 0 {
+		if err := pruneGenerations(client, alias, index, keep); err != nil {
+			log.Fatalln(err)
+		}
+	}
+}
+
+// counts requests retried by the client so they can be reported in the summary
+var retriedRequests uint64
+
+func withRetries(config *elasticsearch.Config) {
+	config.RetryOnStatus = []int{
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout,
+		http.StatusTooManyRequests,
+	}
+	config.MaxRetries = 5
+	config.RetryBackoff = func(attempt int) time.Duration {
+		atomic.AddUint64(&retriedRequests, 1)
+		return time.Duration(1<<attempt) * 100 * time.Millisecond
+	}
+}
+
+func main() {
+	log.SetFlags(log.Lshortfile)
+
+	// acquiring command-line arguments data
+	parsedArgs, commands, err := args.ParseArgs(getDefaultFlags()...)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	command := "import"
+	if len(commands) > 0 {
+		command = commands[0]
+	}
+
+	// dry run never touches elasticsearch
+	if command == "import" && parsedArgs["dry-run"].(bool) {
+		dryRun(parsedArgs, os.Stdout)
+		return
+	}
+
+	// reading certificate
+	if parsedArgs["cacert"].(string) == "" {
+		log.Fatalf("flag \"cacert\" is required by the %s command", command)
+	}
+	CACert, err := os.ReadFile(parsedArgs["cacert"].(string))
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	client, err := db.CreateClient(CACert, withRetries)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	log.Println("Created elasticsearch client")
+
+	switch command {
+	case "import":
+		importRecords(client, parsedArgs)
+	case "rollback":
+		if err := rollback(client, parsedArgs["index"].(string)); err != nil {
+			log.Fatalln(err)
+		}
+	case "migrate":
+		statusOnly := len(commands) > 1 && commands[1] == "status"
+		if err := migrate(client, parsedArgs, statusOnly); err != nil {
+			log.Fatalln(err)
+		}
+	case "dedup":
+		if err := dedup(client, parsedArgs); err != nil {
+			log.Fatalln(err)
+		}
+	default:
+		log.Fatalf("unknown command \"%s\", expected \"import\", \"rollback\", \"migrate\" or \"dedup\"", command)
+	}
 }
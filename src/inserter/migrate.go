@@ -0,0 +1,357 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"path"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/elastic/go-elasticsearch/v8"
+)
+
+// Every migration is a complete index definition named "0001_description.json",
+// where the number is the schema version. Migrations describe the desired state
+// rather than steps, so only the latest one is applied. The version is written
+// to "_meta" of the mapping, so an index knows which migration it conforms to.
+//
+//go:embed migrations/*.json
+var migrationFiles embed.FS
+
+type migration struct {
+	version    int
+	name       string
+	definition indexDefinition
+}
+
+// returns migrations sorted from the oldest to the latest one
+func loadMigrations() ([]migration, error) {
+	paths, err := fs.Glob(migrationFiles, "migrations/*.json")
+	if err != nil {
+		return nil, err
+	}
+
+	migrations := make([]migration, 0, len(paths))
+	for _, migrationPath := range paths {
+		name := strings.TrimSuffix(path.Base(migrationPath), ".json")
+		prefix, _, found := strings.Cut(name, "_")
+		version, err := strconv.Atoi(prefix)
+		if !found || err != nil || version <= 0 {
+			return nil, fmt.Errorf("migration \"%s\" must be named like \"0001_description.json\"", migrationPath)
+		}
+
+		content, err := migrationFiles.ReadFile(migrationPath)
+		if err != nil {
+			return nil, err
+		}
+		definition, err := parseIndexDefinition(content, migrationPath)
+		if err != nil {
+			return nil, err
+		}
+
+		meta, ok := definition.mappings()["_meta"].(map[string]any)
+		if !ok {
+			meta = make(map[string]any)
+			definition.mappings()["_meta"] = meta
+		}
+		meta["schema_version"] = version
+
+		migrations = append(migrations, migration{version: version, name: name, definition: definition})
+	}
+
+	if len(migrations) == 0 {
+		return nil, fmt.Errorf("there are no migrations")
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].version < migrations[j].version
+	})
+	for i := 1; i < len(migrations); i++ {
+		if migrations[i].version == migrations[i-1].version {
+			return nil, fmt.Errorf("migrations \"%s\" and \"%s\" have the same version", migrations[i-1].name, migrations[i].name)
+		}
+	}
+
+	return migrations, nil
+}
+
+// mapping of the index the alias points to
+type currentMapping struct {
+	index    string
+	version  int // 0 for indices created before migrations were introduced
+	mappings map[string]any
+}
+
+// returns nil if the index doesn't exist
+func getCurrentMapping(client *elasticsearch.Client, alias string) (*currentMapping, error) {
+	response, err := client.Indices.GetMapping(client.Indices.GetMapping.WithIndex(alias))
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if response.IsError() {
+		return nil, fmt.Errorf("%s", response)
+	}
+
+	var indices map[string]struct {
+		Mappings map[string]any `json:"mappings"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&indices); err != nil {
+		return nil, err
+	}
+	if len(indices) != 1 {
+		return nil, fmt.Errorf("alias \"%s\" must point to exactly one index, got %d", alias, len(indices))
+	}
+
+	current := &currentMapping{}
+	for index, mapping := range indices {
+		current.index, current.mappings = index, mapping.Mappings
+	}
+	if meta, ok := current.mappings["_meta"].(map[string]any); ok {
+		if version, ok := meta["schema_version"].(float64); ok {
+			current.version = int(version)
+		}
+	}
+
+	return current, nil
+}
+
+// fields are named by their full path, e.g. "location" or "hours.open"
+type mappingChanges struct {
+	added    []string
+	breaking []string // changes elasticsearch can't apply to an existing index
+}
+
+func diffMappings(current, desired map[string]any) mappingChanges {
+	var changes mappingChanges
+
+	// mapping parameters like "dynamic" are compared as a whole
+	if isChanged(current, desired) {
+		changes.breaking = append(changes.breaking, "mapping parameters changed")
+	}
+
+	diffProperties(properties(current), properties(desired), "", &changes)
+	return changes
+}
+
+// compares parameters of a field or a mapping, except for nested properties and metadata
+func isChanged(current, desired map[string]any) bool {
+	for _, parameters := range []map[string]any{current, desired} {
+		for parameter := range parameters {
+			if parameter == "properties" || parameter == "_meta" {
+				continue
+			}
+			if !reflect.DeepEqual(current[parameter], desired[parameter]) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func properties(field map[string]any) map[string]any {
+	fieldProperties, _ := field["properties"].(map[string]any)
+	return fieldProperties
+}
+
+func diffProperties(current, desired map[string]any, prefix string, changes *mappingChanges) {
+	names := make([]string, 0, len(desired))
+	for name := range desired {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		desiredField, _ := desired[name].(map[string]any)
+		currentField, exists := current[name].(map[string]any)
+		if !exists {
+			changes.added = append(changes.added, prefix+name)
+			continue
+		}
+
+		if isChanged(currentField, desiredField) {
+			changes.breaking = append(changes.breaking, fmt.Sprintf("%s: definition changed", prefix+name))
+		}
+
+		diffProperties(properties(currentField), properties(desiredField), prefix+name+".", changes)
+	}
+
+	removed := make([]string, 0)
+	for name := range current {
+		if _, exists := desired[name]; !exists {
+			removed = append(removed, name)
+		}
+	}
+	sort.Strings(removed)
+	for _, name := range removed {
+		changes.breaking = append(changes.breaking, fmt.Sprintf("%s: removed", prefix+name))
+	}
+}
+
+// adds new fields to the existing index and updates its schema version
+func putMapping(client *elasticsearch.Client, index string, mappings map[string]any) error {
+	body, err := json.Marshal(mappings)
+	if err != nil {
+		return err
+	}
+
+	response, err := client.Indices.PutMapping([]string{index}, strings.NewReader(string(body)))
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.IsError() {
+		return fmt.Errorf("%s", response)
+	}
+
+	return nil
+}
+
+// copies documents into a new generation created with the definition and points the alias to it
+func reindexGeneration(client *elasticsearch.Client, alias, source string, definition indexDefinition) error {
+	index, err := createGeneration(client, alias, definition.String())
+	if err != nil {
+		return err
+	}
+
+	failure := func(err error) error {
+		if err := deleteIndices(client, index); err != nil {
+			log.Println(err)
+		}
+		return fmt.Errorf("%s, alias \"%s\" is left untouched", err, alias)
+	}
+
+	body, _ := json.Marshal(map[string]any{
+		"source": map[string]string{"index": source},
+		"dest":   map[string]string{"index": index},
+	})
+	response, err := client.Reindex(
+		strings.NewReader(string(body)),
+		client.Reindex.WithWaitForCompletion(true),
+		client.Reindex.WithRefresh(true),
+	)
+	if err != nil {
+		return failure(err)
+	}
+	defer response.Body.Close()
+
+	if response.IsError() {
+		return failure(fmt.Errorf("%s", response))
+	}
+
+	var result struct {
+		Total    int               `json:"total"`
+		Failures []json.RawMessage `json:"failures"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&result); err != nil {
+		return failure(err)
+	}
+	if len(result.Failures) > 0 {
+		return failure(fmt.Errorf("%d documents failed to reindex, first failure: %s", len(result.Failures), result.Failures[0]))
+	}
+	log.Printf("Reindexed %d documents from \"%s\" into \"%s\"\n", result.Total, source, index)
+
+	sourceCount, err := countDocuments(client, source)
+	if err != nil {
+		return failure(err)
+	}
+	count, err := countDocuments(client, index)
+	if err != nil {
+		return failure(err)
+	}
+	if count != sourceCount {
+		return failure(fmt.Errorf("index \"%s\" contains %d documents instead of %d", index, count, sourceCount))
+	}
+
+	return switchAlias(client, alias, index)
+}
+
+// brings the index behind the alias to the latest migration, "migrate status" only reports the difference
+func migrate(client *elasticsearch.Client, parsedArgs map[string]any, statusOnly bool) error {
+	alias := parsedArgs["index"].(string)
+
+	migrations, err := loadMigrations()
+	if err != nil {
+		return err
+	}
+	desired := migrations[len(migrations)-1]
+
+	current, err := getCurrentMapping(client, alias)
+	if err != nil {
+		return err
+	}
+	if current == nil {
+		return fmt.Errorf("index \"%s\" doesn't exist, run import first", alias)
+	}
+
+	changes := diffMappings(current.mappings, desired.definition.mappings())
+	upToDate := current.version == desired.version && len(changes.added) == 0 && len(changes.breaking) == 0
+
+	if statusOnly {
+		action := "none, up to date"
+		switch {
+		case current.version > desired.version:
+			action = "none, index is newer than the latest migration"
+		case len(changes.breaking) > 0:
+			action = "reindex into a new generation"
+		case !upToDate:
+			action = "update mapping in place"
+		}
+
+		fmt.Printf(
+			"Alias: %s\nIndex: %s\nCurrent schema version: %d\nDesired schema version: %d (%s)\nAdded fields: %q\nBreaking changes: %q\nMigration: %s\n",
+			alias,
+			current.index,
+			current.version,
+			desired.version,
+			desired.name,
+			changes.added,
+			changes.breaking,
+			action,
+		)
+		return nil
+	}
+
+	if current.version > desired.version {
+		return fmt.Errorf(
+			"index \"%s\" has schema version %d, while the latest migration is %d",
+			current.index,
+			current.version,
+			desired.version,
+		)
+	}
+	if upToDate {
+		log.Printf("Index \"%s\" is up to date with schema version %d\n", current.index, current.version)
+		return nil
+	}
+
+	if len(changes.breaking) == 0 {
+		if err := putMapping(client, current.index, desired.definition.mappings()); err != nil {
+			return err
+		}
+		log.Printf("Migrated \"%s\" to schema version %d in place, added fields %q\n", current.index, desired.version, changes.added)
+		return nil
+	}
+
+	log.Printf("Schema version %d can't be applied in place: %q\n", desired.version, changes.breaking)
+
+	definition := desired.definition
+	overrideSettings(definition, parsedArgs)
+	if err := reindexGeneration(client, alias, current.index, definition); err != nil {
+		return err
+	}
+	log.Printf("Migrated \"%s\" to schema version %d\n", alias, desired.version)
+
+	return nil
+}
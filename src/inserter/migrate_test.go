@@ -0,0 +1,177 @@
+package main
+
+import (
+	"common"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func parseMapping(t *testing.T, mapping string) map[string]any {
+	t.Helper()
+
+	var parsed map[string]any
+	if err := json.Unmarshal([]byte(mapping), &parsed); err != nil {
+		t.Fatal(err)
+	}
+	return parsed
+}
+
+func TestDiffMappings(t *testing.T) {
+	desired := `{
+		"_meta": {"schema_version": 2},
+		"properties": {
+			"id": {"type": "long"},
+			"name": {"type": "text"},
+			"location": {"type": "geo_point"},
+			"opening_hours": {"properties": {"weekly": {"properties": {"day": {"type": "byte"}}}}}
+		}
+	}`
+
+	tests := []struct {
+		name     string
+		current  string
+		added    []string
+		breaking []string
+	}{
+		{
+			name: "same mapping of an older version",
+			current: `{
+				"_meta": {"schema_version": 1},
+				"properties": {
+					"id": {"type": "long"},
+					"name": {"type": "text"},
+					"location": {"type": "geo_point"},
+					"opening_hours": {"properties": {"weekly": {"properties": {"day": {"type": "byte"}}}}}
+				}
+			}`,
+		},
+		{
+			name: "added fields",
+			current: `{
+				"properties": {
+					"id": {"type": "long"},
+					"name": {"type": "text"},
+					"opening_hours": {"properties": {"weekly": {"properties": {}}}}
+				}
+			}`,
+			added: []string{"location", "opening_hours.weekly.day"},
+		},
+		{
+			name: "changed and removed fields",
+			current: `{
+				"properties": {
+					"id": {"type": "long"},
+					"name": {"type": "keyword"},
+					"location": {"type": "geo_point"},
+					"phone": {"type": "text"},
+					"opening_hours": {"properties": {"weekly": {"properties": {"day": {"type": "byte"}, "open": {"type": "keyword"}}}}}
+				}
+			}`,
+			breaking: []string{"name: definition changed", "opening_hours.weekly.open: removed", "phone: removed"},
+		},
+		{
+			name: "changed mapping parameters",
+			current: `{
+				"dynamic": "strict",
+				"properties": {
+					"id": {"type": "long"},
+					"name": {"type": "text"},
+					"location": {"type": "geo_point"},
+					"opening_hours": {"properties": {"weekly": {"properties": {"day": {"type": "byte"}}}}}
+				}
+			}`,
+			breaking: []string{"mapping parameters changed"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			changes := diffMappings(parseMapping(t, test.current), parseMapping(t, desired))
+			if len(changes.added) != len(test.added) || (len(test.added) > 0 && !reflect.DeepEqual(changes.added, test.added)) {
+				t.Errorf("added = %q, want %q", changes.added, test.added)
+			}
+			if len(changes.breaking) != len(test.breaking) || (len(test.breaking) > 0 && !reflect.DeepEqual(changes.breaking, test.breaking)) {
+				t.Errorf("breaking = %q, want %q", changes.breaking, test.breaking)
+			}
+		})
+	}
+}
+
+// A field missing from the latest migration is mapped dynamically by elasticsearch,
+// which the next "migrate" takes for a removed field and reindexes over and over.
+func TestLatestMigrationMapsEveryField(t *testing.T) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	latest := properties(migrations[len(migrations)-1].definition.mappings())
+
+	now := time.Now()
+	place := common.Place{
+		ID:            1,
+		District:      "district",
+		Cuisine:       []string{"cuisine"},
+		OpeningHours:  &common.OpeningHours{Exceptions: []common.OpeningException{{Closed: true}}},
+		PriceLevel:    1,
+		Website:       "https://example.com",
+		CreatedAt:     &now,
+		UpdatedAt:     &now,
+		RatingAverage: 1,
+		RatingCount:   1,
+		MergedIDs:     []uint64{2},
+	}
+	marshalizedPlace, err := json.Marshal(place)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var document map[string]any
+	if err := json.Unmarshal(marshalizedPlace, &document); err != nil {
+		t.Fatal(err)
+	}
+	document["content_hash"] = ""
+
+	for field := range document {
+		if _, mapped := latest[field]; !mapped {
+			t.Errorf("field %q is not mapped by the latest migration", field)
+		}
+	}
+}
+
+// indices of version 6 have id mapped dynamically as long, which version 7 maps explicitly the same way
+func TestExplicitIDMappingIsNotBreaking(t *testing.T) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var previous, explicit map[string]any
+	for _, migration := range migrations {
+		switch migration.version {
+		case 6:
+			previous = migration.definition.mappings()
+		case 7:
+			explicit = migration.definition.mappings()
+		}
+	}
+	if previous == nil || explicit == nil {
+		t.Fatal("migrations 6 and 7 are missing")
+	}
+
+	current, err := json.Marshal(previous)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dynamic := parseMapping(t, string(current))
+	if _, mapped := properties(dynamic)["id"]; mapped {
+		t.Fatal("migration 6 maps id, released migrations must not be changed")
+	}
+	properties(dynamic)["id"] = map[string]any{"type": "long"}
+
+	changes := diffMappings(dynamic, explicit)
+	if len(changes.added) != 0 || len(changes.breaking) != 0 {
+		t.Errorf("added %q and breaking %q, want no changes", changes.added, changes.breaking)
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// periodically reports how far the import has got
+type progressReporter struct {
+	started    time.Time
+	pipeline   *pipeline
+	input      *dataInput
+	isTerminal bool
+	output     io.Writer // where the progress bar is drawn
+	stop       chan struct{}
+	stopped    chan struct{}
+}
+
+// draws a progress bar on a terminal and writes log lines otherwise
+func startProgress(interval time.Duration, p *pipeline, input *dataInput) *progressReporter {
+	reporter := &progressReporter{
+		started:  time.Now(),
+		pipeline: p,
+		input:    input,
+		output:   os.Stderr,
+		stop:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+
+	if info, err := os.Stderr.Stat(); err == nil {
+		reporter.isTerminal = info.Mode()&os.ModeCharDevice != 0
+	}
+
+	go func() {
+		defer close(reporter.stopped)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				reporter.report()
+			case <-reporter.stop:
+				if reporter.isTerminal {
+					fmt.Fprintln(reporter.output)
+				}
+				return
+			}
+		}
+	}()
+
+	return reporter
+}
+
+func (reporter *progressReporter) Stop() {
+	close(reporter.stop)
+	<-reporter.stopped
+}
+
+func (reporter *progressReporter) report() {
+	elapsed := time.Since(reporter.started)
+	readed := atomic.LoadUint64(&reporter.pipeline.summary.Readed)
+	indexed := atomic.LoadUint64(&reporter.pipeline.summary.Inserted) + atomic.LoadUint64(&reporter.pipeline.summary.Updated)
+	failed := reporter.pipeline.deadLetters.rejected()
+	rate := float64(readed) / elapsed.Seconds()
+	fraction, eta := reporter.estimate(elapsed)
+
+	if !reporter.isTerminal {
+		percent := "unknown"
+		if fraction >= 0 {
+			percent = fmt.Sprintf("%.1f", fraction*100)
+		}
+
+		log.Printf(
+			"progress read=%d indexed=%d failed=%d rate=%.1f/s percent=%s eta=%s\n",
+			readed, indexed, failed, rate, percent, formatEta(eta),
+		)
+		return
+	}
+
+	const width = 30
+	bar := strings.Repeat("?", width)
+	if fraction >= 0 {
+		filled := int(fraction * width)
+		bar = strings.Repeat("=", filled) + strings.Repeat(" ", width-filled)
+	}
+
+	fmt.Fprintf(
+		reporter.output,
+		"\r[%s] read %d, indexed %d, failed %d, %.0f rows/s, ETA %s\033[K",
+		bar, readed, indexed, failed, rate, formatEta(eta),
+	)
+}
+
+// Share of the file read and the time left, both are negative
+// unless the size of the file is known and reading has started.
+func (reporter *progressReporter) estimate(elapsed time.Duration) (float64, time.Duration) {
+	readBytes := reporter.input.raw.readBytes()
+	if reporter.input.size <= 0 || readBytes == 0 {
+		return -1, -1
+	}
+
+	fraction := min(float64(readBytes)/float64(reporter.input.size), 1)
+	return fraction, time.Duration(float64(elapsed) * (1 - fraction) / fraction).Round(time.Second)
+}
+
+func formatEta(eta time.Duration) string {
+	if eta < 0 {
+		return "unknown"
+	}
+	return eta.String()
+}
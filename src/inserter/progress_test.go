@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProgressEstimate(t *testing.T) {
+	tests := []struct {
+		name      string
+		size      int64
+		readBytes uint64
+		elapsed   time.Duration
+		fraction  float64
+		eta       string
+	}{
+		{name: "half", size: 1000, readBytes: 500, elapsed: 10 * time.Second, fraction: 0.5, eta: "10s"},
+		{name: "quarter", size: 1000, readBytes: 250, elapsed: 10 * time.Second, fraction: 0.25, eta: "30s"},
+		{name: "rounded", size: 3000, readBytes: 1000, elapsed: 1500 * time.Millisecond, fraction: 1.0 / 3, eta: "3s"},
+		{name: "everything read", size: 1000, readBytes: 1000, elapsed: time.Minute, fraction: 1, eta: "0s"},
+		// a file growing while it's read is never read beyond the end
+		{name: "beyond size", size: 1000, readBytes: 1200, elapsed: time.Minute, fraction: 1, eta: "0s"},
+		// stdin has no size
+		{name: "unknown size", readBytes: 500, elapsed: 10 * time.Second, fraction: -1, eta: "unknown"},
+		{name: "nothing read", size: 1000, elapsed: 10 * time.Second, fraction: -1, eta: "unknown"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			reporter := &progressReporter{
+				input: &dataInput{size: test.size, raw: &countingReader{count: test.readBytes}},
+			}
+
+			fraction, eta := reporter.estimate(test.elapsed)
+			if fraction != test.fraction || formatEta(eta) != test.eta {
+				t.Errorf("estimated %v read and ETA %s, want %v and %s", fraction, formatEta(eta), test.fraction, test.eta)
+			}
+		})
+	}
+}
+
+func TestProgressBar(t *testing.T) {
+	tests := []struct {
+		name      string
+		size      int64
+		readBytes uint64
+		bar       string
+	}{
+		{name: "empty", size: 1000, readBytes: 0, bar: "[" + strings.Repeat("?", 30) + "]"},
+		{name: "third", size: 900, readBytes: 300, bar: "[" + strings.Repeat("=", 10) + strings.Repeat(" ", 20) + "]"},
+		{name: "full", size: 900, readBytes: 900, bar: "[" + strings.Repeat("=", 30) + "]"},
+		{name: "unknown size", readBytes: 300, bar: "[" + strings.Repeat("?", 30) + "]"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var output bytes.Buffer
+			importPipeline := &pipeline{deadLetters: &deadLetter{count: 2}}
+			importPipeline.summary = importSummary{Readed: 12, Inserted: 7, Updated: 3}
+
+			reporter := &progressReporter{
+				started:    time.Now().Add(-time.Minute),
+				pipeline:   importPipeline,
+				input:      &dataInput{size: test.size, raw: &countingReader{count: test.readBytes}},
+				isTerminal: true,
+				output:     &output,
+			}
+			reporter.report()
+
+			want := "\r" + test.bar + " read 12, indexed 10, failed 2, "
+			if !strings.HasPrefix(output.String(), want) {
+				t.Errorf("progress %q, want it to start with %q", output.String(), want)
+			}
+		})
+	}
+}
+
+func TestImportReportJSON(t *testing.T) {
+	tests := []struct {
+		name   string
+		report importReport
+		keys   []string
+	}{
+		{
+			name: "fresh import",
+			report: importReport{
+				Index:   "places-20261019T153012",
+				Mode:    modeReplace,
+				Records: importSummary{Readed: 3, Inserted: 3},
+			},
+			keys: []string{"bulk", "duration_seconds", "index", "mode", "records", "validation"},
+		},
+		{
+			// the dead letter file is reported only when records were rejected
+			name: "resumed with rejected records",
+			report: importReport{
+				Index:          "places",
+				Mode:           modeUpsert,
+				ResumedRecords: 10,
+				Records:        importSummary{Readed: 3, Inserted: 2, Rejected: 1},
+				DeadLetter:     "dead_letter.tsv",
+			},
+			keys: []string{"bulk", "dead_letter", "duration_seconds", "index", "mode", "records", "resumed_records", "validation"},
+		},
+	}
+
+	recordKeys := []string{"deleted", "duplicate", "inserted", "merged", "read", "rejected", "unchanged", "updated"}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			marshalized, err := json.Marshal(test.report)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var decoded map[string]json.RawMessage
+			if err := json.Unmarshal(marshalized, &decoded); err != nil {
+				t.Fatal(err)
+			}
+			if keys := sortedKeys(decoded); !reflect.DeepEqual(keys, test.keys) {
+				t.Errorf("report has keys %v, want %v", keys, test.keys)
+			}
+
+			var records map[string]json.RawMessage
+			if err := json.Unmarshal(decoded["records"], &records); err != nil {
+				t.Fatal(err)
+			}
+			if keys := sortedKeys(records); !reflect.DeepEqual(keys, recordKeys) {
+				t.Errorf("records have keys %v, want %v", keys, recordKeys)
+			}
+
+			var summary importSummary
+			if err := json.Unmarshal(decoded["records"], &summary); err != nil {
+				t.Fatal(err)
+			}
+			if summary != test.report.Records {
+				t.Errorf("records %s don't match the summary %+v", decoded["records"], test.report.Records)
+			}
+		})
+	}
+}
+
+func sortedKeys[T any](object map[string]T) []string {
+	keys := make([]string, 0, len(object))
+	for key := range object {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,340 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	formatTsv     = "tsv"
+	formatCsv     = "csv"
+	formatJson    = "json"
+	formatNdjson  = "ndjson"
+	formatGeojson = "geojson"
+)
+
+// Source of records for the indexing pipeline. Every record is a list
+// of values matching the header, so the schema maps all formats the same way.
+type recordReader interface {
+	// returns column names, must be called once before Read
+	Header() ([]string, error)
+
+	// returns the next record along with the line (or the position
+	// of the element for JSON formats) it starts on, io.EOF when there are no more
+	Read() ([]string, int, error)
+}
+
+// chooses the reader by format, falling back to the file extension and contents,
+// objects of JSON formats are flattened into columns the schema refers to
+func newRecordReader(input io.Reader, path, format string, recordSchema *schema) (recordReader, error) {
+	buffered := bufio.NewReader(input)
+
+	if format == "" {
+		format = detectFormat(buffered, path)
+	}
+
+	var objects objectSource
+	switch format {
+	case formatTsv:
+		return newCsvRecordReader(buffered, '\t'), nil
+	case formatCsv:
+		return newCsvRecordReader(buffered, ','), nil
+	case formatJson:
+		objects = newJsonArrayObjects(buffered)
+	case formatNdjson:
+		objects = newNdjsonObjects(buffered)
+	case formatGeojson:
+		objects = newGeojsonObjects(buffered)
+	default:
+		return nil, fmt.Errorf(
+			"unknown format \"%s\", expected one of %q",
+			format,
+			[]string{formatTsv, formatCsv, formatJson, formatNdjson, formatGeojson},
+		)
+	}
+
+	header, err := recordSchema.objectHeader()
+	if err != nil {
+		return nil, fmt.Errorf("%s format: %w", format, err)
+	}
+	return newObjectReader(objects, header), nil
+}
+
+func detectFormat(input *bufio.Reader, path string) string {
+	// the first kilobyte is enough to tell similar formats apart
+	head, _ := input.Peek(1024)
+	trimmedHead := bytes.TrimSpace(head)
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".tsv", ".tab":
+		return formatTsv
+	case ".ndjson", ".jsonl":
+		return formatNdjson
+	case ".geojson":
+		return formatGeojson
+	case ".json":
+		// feature collection is an object, while plain JSON is an array of places
+		if bytes.HasPrefix(trimmedHead, []byte("{")) {
+			return formatGeojson
+		}
+		return formatJson
+	}
+
+	// there is no extension to rely on when reading from stdin
+	switch {
+	case bytes.HasPrefix(trimmedHead, []byte("[")):
+		return formatJson
+	case bytes.HasPrefix(trimmedHead, []byte("{")):
+		if bytes.Contains(head, []byte("FeatureCollection")) {
+			return formatGeojson
+		}
+		return formatNdjson
+	}
+
+	// data.csv is tab-separated, so the delimiter is guessed from the header
+	firstLine, _, _ := bytes.Cut(head, []byte("\n"))
+	if bytes.Contains(firstLine, []byte("\t")) {
+		return formatTsv
+	}
+	return formatCsv
+}
+
+type csvRecordReader struct {
+	reader *csv.Reader
+}
+
+func newCsvRecordReader(input io.Reader, comma rune) *csvRecordReader {
+	reader := csv.NewReader(input)
+	reader.Comma = comma
+	reader.FieldsPerRecord = -1
+
+	// trimming would eat the empty first column of the header
+	// since the delimiter is a white space, values are trimmed by the schema
+	reader.TrimLeadingSpace = false
+
+	return &csvRecordReader{reader: reader}
+}
+
+func (r *csvRecordReader) Header() ([]string, error) {
+	return r.reader.Read()
+}
+
+func (r *csvRecordReader) Read() ([]string, int, error) {
+	record, err := r.reader.Read()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	line, _ := r.reader.FieldPos(0)
+	return record, line, nil
+}
+
+// returns the next object of the input along with its line or position, io.EOF at the end
+type objectSource func() (map[string]any, int, error)
+
+// Flattens JSON objects into records of the given keys, so every object is mapped
+// by its keys whatever keys the other objects have. Absent keys are empty values,
+// while keys out of the header are ignored.
+type objectReader struct {
+	next        objectSource
+	header      []string
+	pending     map[string]any
+	pendingLine int
+}
+
+func newObjectReader(next objectSource, header []string) *objectReader {
+	return &objectReader{next: next, header: header}
+}
+
+// reads the first object ahead, so an empty or broken input fails the same way a table does
+func (r *objectReader) Header() ([]string, error) {
+	object, line, err := r.next()
+	if err != nil {
+		return nil, err
+	}
+
+	r.pending, r.pendingLine = object, line
+	return r.header, nil
+}
+
+func (r *objectReader) Read() ([]string, int, error) {
+	object, line := r.pending, r.pendingLine
+	if object == nil {
+		var err error
+		if object, line, err = r.next(); err != nil {
+			return nil, 0, err
+		}
+	}
+	r.pending = nil
+
+	record := make([]string, len(r.header))
+	for i, key := range r.header {
+		record[i] = stringifyValue(object[key])
+	}
+
+	return record, line, nil
+}
+
+func stringifyValue(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case bool:
+		return fmt.Sprint(v)
+	default:
+		marshalized, _ := json.Marshal(v)
+		return string(marshalized)
+	}
+}
+
+func newJsonArrayObjects(input io.Reader) objectSource {
+	decoder := json.NewDecoder(input)
+	decoder.UseNumber()
+
+	position, started := 0, false
+	return func() (map[string]any, int, error) {
+		if !started {
+			if err := expectDelimiter(decoder, '['); err != nil {
+				return nil, 0, err
+			}
+			started = true
+		}
+
+		if !decoder.More() {
+			return nil, 0, io.EOF
+		}
+
+		position++
+		var object map[string]any
+		if err := decoder.Decode(&object); err != nil {
+			return nil, 0, fmt.Errorf("element %d: %w", position, err)
+		}
+
+		return object, position, nil
+	}
+}
+
+func newNdjsonObjects(input *bufio.Reader) objectSource {
+	line := 0
+	return func() (map[string]any, int, error) {
+		for {
+			content, err := input.ReadBytes('\n')
+			if err != nil && (err != io.EOF || len(content) == 0) {
+				return nil, 0, err
+			}
+			line++
+
+			if len(bytes.TrimSpace(content)) == 0 {
+				continue
+			}
+
+			decoder := json.NewDecoder(bytes.NewReader(content))
+			decoder.UseNumber()
+
+			var object map[string]any
+			if err := decoder.Decode(&object); err != nil {
+				return nil, 0, fmt.Errorf("line %d: %w", line, err)
+			}
+
+			return object, line, nil
+		}
+	}
+}
+
+type geojsonFeature struct {
+	ID       any `json:"id"`
+	Geometry *struct {
+		Type        string        `json:"type"`
+		Coordinates []json.Number `json:"coordinates"`
+	} `json:"geometry"`
+	Properties map[string]any `json:"properties"`
+}
+
+// Streams features of a FeatureCollection. Besides properties, every object
+// has "longitude" and "latitude" of a point geometry and "id" of the feature.
+func newGeojsonObjects(input io.Reader) objectSource {
+	decoder := json.NewDecoder(input)
+	decoder.UseNumber()
+
+	position, started := 0, false
+	return func() (map[string]any, int, error) {
+		if !started {
+			if err := seekFeatures(decoder); err != nil {
+				return nil, 0, err
+			}
+			started = true
+		}
+
+		if !decoder.More() {
+			return nil, 0, io.EOF
+		}
+
+		position++
+		var feature geojsonFeature
+		if err := decoder.Decode(&feature); err != nil {
+			return nil, 0, fmt.Errorf("feature %d: %w", position, err)
+		}
+
+		object := feature.Properties
+		if object == nil {
+			object = make(map[string]any)
+		}
+		if _, exists := object["id"]; !exists && feature.ID != nil {
+			object["id"] = feature.ID
+		}
+		if feature.Geometry != nil && feature.Geometry.Type == "Point" && len(feature.Geometry.Coordinates) >= 2 {
+			object["longitude"] = feature.Geometry.Coordinates[0]
+			object["latitude"] = feature.Geometry.Coordinates[1]
+		}
+
+		return object, position, nil
+	}
+}
+
+func expectDelimiter(decoder *json.Decoder, expected json.Delim) error {
+	token, err := decoder.Token()
+	if err != nil {
+		return err
+	}
+
+	if delimiter, ok := token.(json.Delim); !ok || delimiter != expected {
+		return fmt.Errorf("expected %q, got %v", expected, token)
+	}
+
+	return nil
+}
+
+// moves the decoder to the first element of the "features" array
+func seekFeatures(decoder *json.Decoder) error {
+	if err := expectDelimiter(decoder, '{'); err != nil {
+		return err
+	}
+
+	for decoder.More() {
+		key, err := decoder.Token()
+		if err != nil {
+			return err
+		}
+
+		if key == "features" {
+			return expectDelimiter(decoder, '[')
+		}
+
+		var skipped json.RawMessage
+		if err := decoder.Decode(&skipped); err != nil {
+			return err
+		}
+	}
+
+	return fmt.Errorf("no \"features\" in feature collection")
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestObjectReaderMapsFieldsByKey(t *testing.T) {
+	recordSchema := &schema{
+		ID:        &column{Name: "id"},
+		Name:      column{Name: "name"},
+		Phone:     &column{Name: "phone"},
+		Longitude: &column{Name: "lon"},
+		Latitude:  &column{Name: "lat"},
+	}
+
+	tests := []struct {
+		name   string
+		path   string
+		input  string
+		places []string
+	}{
+		{
+			// keys absent in the first object are read from the following ones
+			name:   "keys differ between objects",
+			path:   "places.ndjson",
+			input:  "{\"name\": \"First\", \"id\": 1, \"lon\": 37.6, \"lat\": 55.7}\n{\"id\": 2, \"phone\": \"+74951234567\", \"lat\": 55.8, \"lon\": 37.5, \"name\": \"Second\", \"extra\": true}\n",
+			places: []string{"1 First  37.6 55.7", "2 Second +74951234567 37.5 55.8"},
+		},
+		{
+			name:   "array",
+			path:   "places.json",
+			input:  `[{"lat": 55.7, "lon": 37.6, "name": "Only", "id": 7}]`,
+			places: []string{"7 Only  37.6 55.7"},
+		},
+		{
+			name:   "feature collection",
+			path:   "places.geojson",
+			input:  `{"type": "FeatureCollection", "features": [{"id": 3, "geometry": {"type": "Point", "coordinates": [37.6, 55.7]}, "properties": {"name": "Feature"}}]}`,
+			places: []string{"3 Feature  37.6 55.7"},
+		},
+	}
+
+	geojsonSchema := *recordSchema
+	geojsonSchema.Longitude, geojsonSchema.Latitude = &column{Name: "longitude"}, &column{Name: "latitude"}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			currentSchema := recordSchema
+			if strings.HasSuffix(test.path, ".geojson") {
+				currentSchema = &geojsonSchema
+			}
+
+			reader, err := newRecordReader(strings.NewReader(test.input), test.path, "", currentSchema)
+			if err != nil {
+				t.Fatal(err)
+			}
+			header, err := reader.Header()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			places := make([]string, 0)
+			for {
+				record, _, err := reader.Read()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(record) != len(header) {
+					t.Fatalf("record %q doesn't match header %q", record, header)
+				}
+				places = append(places, strings.Join(record, " "))
+			}
+
+			if !reflect.DeepEqual(places, test.places) {
+				t.Errorf("records = %q, want %q", places, test.places)
+			}
+		})
+	}
+}
+
+func TestObjectReaderRefusesPositions(t *testing.T) {
+	if _, err := newRecordReader(strings.NewReader(`[{"id": 1}]`), "places.json", "", defaultSchema()); err == nil {
+		t.Error("schema referring to the id by position is accepted for JSON")
+	}
+	if _, err := newRecordReader(strings.NewReader(testRecords(1)), "data.csv", "", defaultSchema()); err != nil {
+		t.Errorf("default schema is refused for data.csv: %s", err)
+	}
+}
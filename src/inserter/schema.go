@@ -0,0 +1,361 @@
+package main
+
+import (
+	"bytes"
+	"common"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// reference to a column either by its header name or by its zero-based position
+type column struct {
+	Name       string
+	Position   int
+	byPosition bool
+}
+
+func (c *column) UnmarshalJSON(data []byte) error {
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte(`"`)) {
+		return json.Unmarshal(data, &c.Name)
+	}
+
+	if err := json.Unmarshal(data, &c.Position); err != nil {
+		return fmt.Errorf("column must be either a header name or a position: %w", err)
+	}
+	c.byPosition = true
+
+	return nil
+}
+
+func (c column) String() string {
+	if c.byPosition {
+		return fmt.Sprint(c.Position)
+	}
+	return strconv.Quote(c.Name)
+}
+
+// single column holding both coordinates, e.g. "55.75,37.61"
+type coordinatesColumn struct {
+	Column    column `json:"column"`
+	Order     string `json:"order"`     // either "lat,lon" or "lon,lat"
+	Separator string `json:"separator"` // comma if empty
+}
+
+// Describes which columns of the data file feed fields of common.Place.
+// Coordinates come either from separate longitude and latitude columns
+// or from a single coordinates column.
+type schema struct {
+	ID          *column            `json:"id"`      // column holding document ID
+	IDHash      []column           `json:"id_hash"` // columns to hash into document ID instead
+	Name        column             `json:"name"`
+	Address     *column            `json:"address"`
+	Phone       *column            `json:"phone"`
+	Longitude   *column            `json:"longitude"`
+	Latitude    *column            `json:"latitude"`
+	Coordinates *coordinatesColumn `json:"coordinates"`
+
+	// optional details of a place
+	District     *column `json:"district"`
+	Cuisine      *column `json:"cuisine"`
+	OpeningHours *column `json:"opening_hours"`
+	PriceLevel   *column `json:"price_level"`
+	Website      *column `json:"website"`
+	CreatedAt    *column `json:"created_at"`
+	UpdatedAt    *column `json:"updated_at"`
+}
+
+// schema of datasets/data.csv
+func defaultSchema() *schema {
+	return &schema{
+		ID:        &column{Position: 0, byPosition: true},
+		Name:      column{Name: "Name"},
+		Address:   &column{Name: "Address"},
+		Phone:     &column{Name: "Phone"},
+		Longitude: &column{Name: "Longitude"},
+		Latitude:  &column{Name: "Latitude"},
+	}
+}
+
+// columns the schema refers to, in the order of its fields
+func (s *schema) columns() []column {
+	columns := make([]column, 0)
+	if s.ID != nil {
+		columns = append(columns, *s.ID)
+	}
+	columns = append(columns, s.IDHash...)
+	columns = append(columns, s.Name)
+	for _, c := range []*column{s.Address, s.Phone, s.Longitude, s.Latitude} {
+		if c != nil {
+			columns = append(columns, *c)
+		}
+	}
+	if s.Coordinates != nil {
+		columns = append(columns, s.Coordinates.Column)
+	}
+	for _, c := range []*column{s.District, s.Cuisine, s.OpeningHours, s.PriceLevel, s.Website, s.CreatedAt, s.UpdatedAt} {
+		if c != nil {
+			columns = append(columns, *c)
+		}
+	}
+	return columns
+}
+
+// Keys of JSON objects to read. Keys of objects come in no particular order,
+// so columns referred to by position, like the id of the default schema, are refused.
+func (s *schema) objectHeader() ([]string, error) {
+	header := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, c := range s.columns() {
+		if c.byPosition {
+			return nil, fmt.Errorf("column %s is referred to by position, while objects have keys only, set a schema referring to keys", c)
+		}
+		if !seen[c.Name] {
+			seen[c.Name] = true
+			header = append(header, c.Name)
+		}
+	}
+	return header, nil
+}
+
+func loadSchema(path string) (*schema, error) {
+	if path == "" {
+		return defaultSchema(), nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	decoder := json.NewDecoder(file)
+	decoder.DisallowUnknownFields()
+
+	var parsedSchema schema
+	if err := decoder.Decode(&parsedSchema); err != nil {
+		return nil, fmt.Errorf("invalid schema \"%s\": %w", path, err)
+	}
+
+	return &parsedSchema, nil
+}
+
+// schema bound to positions of a particular header, -1 marks absent columns
+type columnMapping struct {
+	ids         *idGenerator
+	name        int
+	address     int
+	phone       int
+	longitude   int
+	latitude    int
+	coordinates int
+	latFirst    bool
+	separator   string
+
+	district     int
+	cuisine      int
+	openingHours int
+	priceLevel   int
+	website      int
+	createdAt    int
+	updatedAt    int
+}
+
+func findColumn(header []string, c column) (int, error) {
+	if c.byPosition {
+		if c.Position < 0 || c.Position >= len(header) {
+			return 0, fmt.Errorf("column %d is out of range, header has %d columns", c.Position, len(header))
+		}
+		return c.Position, nil
+	}
+
+	for i, name := range header {
+		if strings.TrimSpace(name) == c.Name {
+			return i, nil
+		}
+	}
+
+	return 0, fmt.Errorf("column %s is absent in header", c)
+}
+
+func findOptionalColumn(header []string, c *column) (int, error) {
+	if c == nil {
+		return -1, nil
+	}
+	return findColumn(header, *c)
+}
+
+func (s *schema) resolve(header []string) (*columnMapping, error) {
+	var err error
+	mapping := &columnMapping{ids: &idGenerator{}, coordinates: -1, longitude: -1, latitude: -1}
+
+	switch {
+	case len(s.IDHash) > 0:
+		for _, c := range s.IDHash {
+			position, err := findColumn(header, c)
+			if err != nil {
+				return nil, fmt.Errorf("id_hash: %w", err)
+			}
+			mapping.ids.hashColumns = append(mapping.ids.hashColumns, position)
+		}
+	case s.ID != nil:
+		if mapping.ids.column, err = findColumn(header, *s.ID); err != nil {
+			return nil, fmt.Errorf("id: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("either id or id_hash must be set")
+	}
+
+	if mapping.name, err = findColumn(header, s.Name); err != nil {
+		return nil, fmt.Errorf("name: %w", err)
+	}
+	if mapping.address, err = findOptionalColumn(header, s.Address); err != nil {
+		return nil, fmt.Errorf("address: %w", err)
+	}
+	if mapping.phone, err = findOptionalColumn(header, s.Phone); err != nil {
+		return nil, fmt.Errorf("phone: %w", err)
+	}
+	if mapping.district, err = findOptionalColumn(header, s.District); err != nil {
+		return nil, fmt.Errorf("district: %w", err)
+	}
+	if mapping.cuisine, err = findOptionalColumn(header, s.Cuisine); err != nil {
+		return nil, fmt.Errorf("cuisine: %w", err)
+	}
+	if mapping.openingHours, err = findOptionalColumn(header, s.OpeningHours); err != nil {
+		return nil, fmt.Errorf("opening_hours: %w", err)
+	}
+	if mapping.priceLevel, err = findOptionalColumn(header, s.PriceLevel); err != nil {
+		return nil, fmt.Errorf("price_level: %w", err)
+	}
+	if mapping.website, err = findOptionalColumn(header, s.Website); err != nil {
+		return nil, fmt.Errorf("website: %w", err)
+	}
+	if mapping.createdAt, err = findOptionalColumn(header, s.CreatedAt); err != nil {
+		return nil, fmt.Errorf("created_at: %w", err)
+	}
+	if mapping.updatedAt, err = findOptionalColumn(header, s.UpdatedAt); err != nil {
+		return nil, fmt.Errorf("updated_at: %w", err)
+	}
+
+	if s.Coordinates != nil {
+		if mapping.coordinates, err = findColumn(header, s.Coordinates.Column); err != nil {
+			return nil, fmt.Errorf("coordinates: %w", err)
+		}
+
+		switch strings.ReplaceAll(s.Coordinates.Order, " ", "") {
+		case "lat,lon":
+			mapping.latFirst = true
+		case "lon,lat":
+			mapping.latFirst = false
+		default:
+			return nil, fmt.Errorf("coordinates: order must be either \"lat,lon\" or \"lon,lat\"")
+		}
+
+		mapping.separator = s.Coordinates.Separator
+		if mapping.separator == "" {
+			mapping.separator = ","
+		}
+
+		return mapping, nil
+	}
+
+	if s.Longitude == nil || s.Latitude == nil {
+		return nil, fmt.Errorf("either coordinates or both longitude and latitude must be set")
+	}
+	if mapping.longitude, err = findColumn(header, *s.Longitude); err != nil {
+		return nil, fmt.Errorf("longitude: %w", err)
+	}
+	if mapping.latitude, err = findColumn(header, *s.Latitude); err != nil {
+		return nil, fmt.Errorf("latitude: %w", err)
+	}
+
+	return mapping, nil
+}
+
+func field(record []string, position int) string {
+	if position < 0 || position >= len(record) {
+		return ""
+	}
+	return strings.TrimSpace(record[position])
+}
+
+func parseCoordinate(value, name string) (float64, error) {
+	coordinate, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q", name, value)
+	}
+	return coordinate, nil
+}
+
+func (mapping *columnMapping) location(record []string) (common.Location, error) {
+	var lonValue, latValue string
+	if mapping.coordinates >= 0 {
+		parts := strings.Split(field(record, mapping.coordinates), mapping.separator)
+		if len(parts) != 2 {
+			return common.Location{}, fmt.Errorf("invalid coordinates %q", field(record, mapping.coordinates))
+		}
+
+		latValue, lonValue = parts[0], parts[1]
+		if !mapping.latFirst {
+			latValue, lonValue = lonValue, latValue
+		}
+	} else {
+		lonValue, latValue = field(record, mapping.longitude), field(record, mapping.latitude)
+	}
+
+	lon, err := parseCoordinate(lonValue, "longitude")
+	if err != nil {
+		return common.Location{}, err
+	}
+
+	lat, err := parseCoordinate(latValue, "latitude")
+	if err != nil {
+		return common.Location{}, err
+	}
+
+	return common.Location{Longitude: lon, Latitude: lat}, nil
+}
+
+func (mapping *columnMapping) place(id uint64, record []string) (common.Place, error) {
+	location, err := mapping.location(record)
+	if err != nil {
+		return common.Place{}, err
+	}
+
+	openingHours, err := parseOpeningHours(field(record, mapping.openingHours))
+	if err != nil {
+		return common.Place{}, err
+	}
+
+	priceLevel, err := parsePriceLevel(field(record, mapping.priceLevel))
+	if err != nil {
+		return common.Place{}, err
+	}
+
+	createdAt, err := parseTimestamp(field(record, mapping.createdAt))
+	if err != nil {
+		return common.Place{}, fmt.Errorf("created_at: %w", err)
+	}
+
+	updatedAt, err := parseTimestamp(field(record, mapping.updatedAt))
+	if err != nil {
+		return common.Place{}, fmt.Errorf("updated_at: %w", err)
+	}
+
+	return common.Place{
+		ID:           id,
+		Name:         field(record, mapping.name),
+		Address:      field(record, mapping.address),
+		Phone:        field(record, mapping.phone),
+		Location:     location,
+		District:     field(record, mapping.district),
+		Cuisine:      parseCuisine(field(record, mapping.cuisine)),
+		OpeningHours: openingHours,
+		PriceLevel:   priceLevel,
+		Website:      field(record, mapping.website),
+		CreatedAt:    createdAt,
+		UpdatedAt:    updatedAt,
+	}, nil
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSchemaResolve(t *testing.T) {
+	header := []string{"ID", " Title ", "Street", "Lon", "Lat", "Point"}
+	record := []string{"5", " Cafe ", "Tverskaya 1", "37.61", "55.75", "55.75;37.61"}
+
+	tests := []struct {
+		name   string
+		schema string
+		place  string // id, name, address, longitude and latitude
+		err    string
+	}{
+		{
+			name:   "header names",
+			schema: `{"id": "ID", "name": "Title", "address": "Street", "longitude": "Lon", "latitude": "Lat"}`,
+			place:  "5 Cafe Tverskaya 1 37.61 55.75",
+		},
+		{
+			name:   "positions",
+			schema: `{"id": 0, "name": 1, "longitude": 3, "latitude": 4}`,
+			place:  "5 Cafe  37.61 55.75",
+		},
+		{
+			name:   "coordinates lat,lon",
+			schema: `{"id": "ID", "name": "Title", "coordinates": {"column": "Point", "order": "lat, lon", "separator": ";"}}`,
+			place:  "5 Cafe  37.61 55.75",
+		},
+		{
+			name:   "coordinates lon,lat",
+			schema: `{"id": "ID", "name": "Title", "coordinates": {"column": "Point", "order": "lon,lat", "separator": ";"}}`,
+			place:  "5 Cafe  55.75 37.61",
+		},
+		{
+			name:   "missing column",
+			schema: `{"id": "ID", "name": "Title", "phone": "Phone", "longitude": "Lon", "latitude": "Lat"}`,
+			err:    `phone: column "Phone" is absent in header`,
+		},
+		{
+			name:   "position out of range",
+			schema: `{"id": 6, "name": "Title", "longitude": "Lon", "latitude": "Lat"}`,
+			err:    "id: column 6 is out of range, header has 6 columns",
+		},
+		{
+			name:   "no id",
+			schema: `{"name": "Title", "longitude": "Lon", "latitude": "Lat"}`,
+			err:    "either id or id_hash must be set",
+		},
+		{
+			name:   "no latitude",
+			schema: `{"id": "ID", "name": "Title", "longitude": "Lon"}`,
+			err:    "either coordinates or both longitude and latitude must be set",
+		},
+		{
+			name:   "invalid order",
+			schema: `{"id": "ID", "name": "Title", "coordinates": {"column": "Point", "order": "x,y"}}`,
+			err:    `coordinates: order must be either "lat,lon" or "lon,lat"`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "schema.json")
+			if err := os.WriteFile(path, []byte(test.schema), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			loaded, err := loadSchema(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			mapping, err := loaded.resolve(header)
+			if test.err != "" {
+				if err == nil || err.Error() != test.err {
+					t.Fatalf("resolve returned %v, want %q", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			id, err := mapping.ids.generate(record)
+			if err != nil {
+				t.Fatal(err)
+			}
+			place, err := mapping.place(id, record)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := fmt.Sprintf("%d %s %s %v %v", place.ID, place.Name, place.Address, place.Location.Longitude, place.Location.Latitude)
+			if got != test.place {
+				t.Errorf("place %q, want %q", got, test.place)
+			}
+		})
+	}
+}
+
+func TestLoadSchema(t *testing.T) {
+	tests := []struct {
+		name   string
+		schema string
+		err    string
+	}{
+		{
+			name:   "dataset schema",
+			schema: `{"id": 0, "name": "Name", "address": "Address", "phone": "Phone", "longitude": "Longitude", "latitude": "Latitude"}`,
+		},
+		{
+			name:   "unknown field",
+			schema: `{"id": 0, "name": "Name", "title": "Title"}`,
+			err:    `json: unknown field "title"`,
+		},
+		{
+			name:   "invalid column",
+			schema: `{"id": true, "name": "Name"}`,
+			err:    "column must be either a header name or a position",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "schema.json")
+			if err := os.WriteFile(path, []byte(test.schema), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			_, err := loadSchema(path)
+			if test.err == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("loadSchema returned %v, want an error containing %q", err, test.err)
+			}
+		})
+	}
+
+	// the default schema matches the header of datasets/data.csv
+	loaded, err := loadSchema("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loaded.resolve([]string{"", "Name", "Address", "Phone", "Longitude", "Latitude"}); err != nil {
+		t.Errorf("default schema doesn't match the dataset header: %s", err)
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/elastic/go-elasticsearch/v8"
+)
+
+// body of the create index request, i.e. "settings" and "mappings" of the index
+type indexDefinition map[string]any
+
+// the latest migration is used when no mapping file is given
+func loadIndexDefinition(path string) (indexDefinition, error) {
+	if path == "" {
+		migrations, err := loadMigrations()
+		if err != nil {
+			return nil, err
+		}
+		return migrations[len(migrations)-1].definition, nil
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseIndexDefinition(content, path)
+}
+
+func parseIndexDefinition(content []byte, path string) (indexDefinition, error) {
+	var definition indexDefinition
+	if err := json.Unmarshal(content, &definition); err != nil {
+		return nil, fmt.Errorf("invalid mapping file \"%s\": %w", path, err)
+	}
+	if _, ok := definition["mappings"].(map[string]any); !ok {
+		return nil, fmt.Errorf("mapping file \"%s\" has no \"mappings\"", path)
+	}
+
+	return definition, nil
+}
+
+func (definition indexDefinition) mappings() map[string]any {
+	return definition["mappings"].(map[string]any)
+}
+
+// settings may be written both as {"index": {"name": value}} and as {"name": value}
+func (definition indexDefinition) indexSettings() map[string]any {
+	settings, ok := definition["settings"].(map[string]any)
+	if !ok {
+		settings = make(map[string]any)
+		definition["settings"] = settings
+	}
+
+	index, ok := settings["index"].(map[string]any)
+	if !ok {
+		index = make(map[string]any)
+		settings["index"] = index
+	}
+
+	return index
+}
+
+func (definition indexDefinition) setting(name string) any {
+	if value, exists := definition.indexSettings()[name]; exists {
+		return value
+	}
+	return definition["settings"].(map[string]any)[name]
+}
+
+func (definition indexDefinition) setSetting(name string, value any) {
+	// makes "settings" if the definition has none
+	index := definition.indexSettings()
+	delete(definition["settings"].(map[string]any), name)
+	index[name] = value
+}
+
+func (definition indexDefinition) String() string {
+	marshalized, _ := json.MarshalIndent(definition, "", "  ")
+	return string(marshalized)
+}
+
+// returns refresh interval of the index or of the first index behind the alias, nil if it isn't set
+func getRefreshInterval(client *elasticsearch.Client, index string) (any, error) {
+	response, err := client.Indices.GetSettings(
+		client.Indices.GetSettings.WithIndex(index),
+		client.Indices.GetSettings.WithName("index.refresh_interval"),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.IsError() {
+		return nil, fmt.Errorf("%s", response)
+	}
+
+	var settings map[string]struct {
+		Settings struct {
+			Index struct {
+				RefreshInterval *string `json:"refresh_interval"`
+			} `json:"index"`
+		} `json:"settings"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&settings); err != nil {
+		return nil, err
+	}
+
+	for _, indexSettings := range settings {
+		if interval := indexSettings.Settings.Index.RefreshInterval; interval != nil {
+			return *interval, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// nil value resets refresh interval to the default one
+func setRefreshInterval(client *elasticsearch.Client, index string, value any) error {
+	body, _ := json.Marshal(map[string]any{"index": map[string]any{"refresh_interval": value}})
+
+	response, err := client.Indices.PutSettings(
+		strings.NewReader(string(body)),
+		client.Indices.PutSettings.WithIndex(index),
+	)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.IsError() {
+		return fmt.Errorf("%s", response)
+	}
+
+	return nil
+}
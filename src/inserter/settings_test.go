@@ -0,0 +1,218 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/elastic/go-elasticsearch/v8"
+)
+
+func TestParseIndexDefinition(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		err     string
+	}{
+		{name: "mappings only", content: `{"mappings": {"properties": {"id": {"type": "long"}}}}`},
+		{name: "settings and mappings", content: `{"settings": {"number_of_shards": 1}, "mappings": {}}`},
+		{name: "invalid json", content: `{"mappings": `, err: `invalid mapping file "mapping.json"`},
+		{name: "no mappings", content: `{"settings": {}}`, err: `mapping file "mapping.json" has no "mappings"`},
+		{name: "mappings not an object", content: `{"mappings": []}`, err: `mapping file "mapping.json" has no "mappings"`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := parseIndexDefinition([]byte(test.content), "mapping.json")
+			if test.err == "" {
+				if err != nil {
+					t.Errorf("parseIndexDefinition returned %s", err)
+				}
+				return
+			}
+			if err == nil || !strings.HasPrefix(err.Error(), test.err) {
+				t.Errorf("parseIndexDefinition returned %v, want an error starting with %q", err, test.err)
+			}
+		})
+	}
+}
+
+func TestLoadIndexDefinition(t *testing.T) {
+	// the latest migration is the default
+	definition, err := loadIndexDefinition("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := definition.mappings()["properties"].(map[string]any)["location"]; !ok {
+		t.Errorf("default mapping has no location: %s", definition)
+	}
+
+	path := filepath.Join(t.TempDir(), "mapping.json")
+	if err := os.WriteFile(path, []byte(`{"mappings": {"dynamic": "strict"}}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	definition, err = loadIndexDefinition(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if definition.mappings()["dynamic"] != "strict" {
+		t.Errorf("mapping file isn't loaded: %s", definition)
+	}
+
+	if _, err := loadIndexDefinition(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("missing mapping file is loaded")
+	}
+}
+
+func TestOverrideSettings(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		shards   int
+		replicas int
+		settings string
+	}{
+		{
+			name:     "defaults keep the file",
+			content:  `{"settings": {"index": {"number_of_shards": 3, "number_of_replicas": 2}}, "mappings": {}}`,
+			replicas: -1,
+			settings: `{"index":{"number_of_replicas":2,"number_of_shards":3}}`,
+		},
+		{
+			name:     "nested settings",
+			content:  `{"settings": {"index": {"number_of_shards": 3, "refresh_interval": "5s"}}, "mappings": {}}`,
+			shards:   1,
+			replicas: 0,
+			settings: `{"index":{"number_of_replicas":0,"number_of_shards":1,"refresh_interval":"5s"}}`,
+		},
+		{
+			// flat settings are moved under "index", so they aren't set twice
+			name:     "flat settings",
+			content:  `{"settings": {"number_of_shards": 3, "number_of_replicas": 2}, "mappings": {}}`,
+			shards:   2,
+			replicas: 1,
+			settings: `{"index":{"number_of_replicas":1,"number_of_shards":2}}`,
+		},
+		{
+			name:     "no settings",
+			content:  `{"mappings": {}}`,
+			shards:   4,
+			replicas: -1,
+			settings: `{"index":{"number_of_shards":4}}`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			definition, err := parseIndexDefinition([]byte(test.content), "mapping.json")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			parsedArgs := defaultArgs()
+			parsedArgs["shards"] = test.shards
+			parsedArgs["replicas"] = test.replicas
+			overrideSettings(definition, parsedArgs)
+
+			settings, _ := json.Marshal(definition["settings"])
+			if string(settings) != test.settings {
+				t.Errorf("settings %s, want %s", settings, test.settings)
+			}
+		})
+	}
+}
+
+func TestIndexDefinitionSetting(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    any
+	}{
+		{name: "nested", content: `{"settings": {"index": {"refresh_interval": "5s"}}, "mappings": {}}`, want: "5s"},
+		{name: "flat", content: `{"settings": {"refresh_interval": "5s"}, "mappings": {}}`, want: "5s"},
+		{name: "nested wins", content: `{"settings": {"refresh_interval": "5s", "index": {"refresh_interval": "1s"}}, "mappings": {}}`, want: "1s"},
+		{name: "absent", content: `{"mappings": {}}`, want: nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			definition, err := parseIndexDefinition([]byte(test.content), "mapping.json")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := definition.setting("refresh_interval"); got != test.want {
+				t.Errorf("refresh interval %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestRefreshInterval(t *testing.T) {
+	var putBodies []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Elastic-Product", "Elasticsearch")
+		w.Header().Set("Content-Type", "application/json")
+
+		if r.Method == http.MethodPut {
+			body, _ := io.ReadAll(r.Body)
+			putBodies = append(putBodies, r.URL.Path+" "+string(body))
+			w.Write([]byte(`{"acknowledged": true}`))
+			return
+		}
+
+		switch r.URL.Path {
+		case "/places/_settings/index.refresh_interval":
+			// the alias points to a generation with the interval set
+			w.Write([]byte(`{"places-20261019T153012": {"settings": {"index": {"refresh_interval": "30s"}}}}`))
+		case "/fresh/_settings/index.refresh_interval":
+			w.Write([]byte(`{"fresh": {"settings": {}}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error": {"type": "index_not_found_exception"}, "status": 404}`))
+		}
+	}))
+	defer server.Close()
+
+	client, err := elasticsearch.NewClient(elasticsearch.Config{Addresses: []string{server.URL}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		index   string
+		want    any
+		wantErr bool
+	}{
+		{index: "places", want: "30s"},
+		{index: "fresh", want: nil},
+		{index: "missing", wantErr: true},
+	}
+
+	for _, test := range tests {
+		got, err := getRefreshInterval(client, test.index)
+		if got != test.want || (err != nil) != test.wantErr {
+			t.Errorf("getRefreshInterval(%q) = %v, %v, want %v and error %v", test.index, got, err, test.want, test.wantErr)
+		}
+	}
+
+	if err := setRefreshInterval(client, "places", "-1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := setRefreshInterval(client, "places", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// nil resets the interval to the default one
+	want := []string{
+		`/places/_settings {"index":{"refresh_interval":"-1"}}`,
+		`/places/_settings {"index":{"refresh_interval":null}}`,
+	}
+	if strings.Join(putBodies, "\n") != strings.Join(want, "\n") {
+		t.Errorf("settings put %q, want %q", putBodies, want)
+	}
+}
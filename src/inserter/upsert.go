@@ -0,0 +1,173 @@
+package main
+
+import (
+	"common"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8"
+)
+
+const (
+	modeReplace = "replace"
+	modeAppend  = "append"
+	modeUpsert  = "upsert"
+)
+
+// document as it is stored in the index
+type document struct {
+	common.Place
+	ContentHash string `json:"content_hash"`
+}
+
+// timestamps, ratings and merged ids are left out, so they don't make an unchanged place look updated
+func contentHash(place common.Place) (string, error) {
+	place.CreatedAt, place.UpdatedAt = nil, nil
+	place.RatingAverage, place.RatingCount = 0, 0
+	place.MergedIDs = nil
+
+	marshalizedPlace, err := json.Marshal(place)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(marshalizedPlace)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func marshalDocument(place common.Place, hash string) ([]byte, error) {
+	return json.Marshal(document{Place: place, ContentHash: hash})
+}
+
+// Timestamps of the data file take precedence. Otherwise created_at is kept
+// from the previous version of the document and updated_at changes along
+// with the content, previous is nil for new documents. Ratings come from
+// reviews and merged ids from the dedup command rather than the data file,
+// so they are carried over as well.
+func stampPlace(place *common.Place, previous *existingDocument, hash string, now time.Time) {
+	if previous != nil {
+		place.RatingAverage, place.RatingCount = previous.RatingAverage, previous.RatingCount
+		place.MergedIDs = previous.MergedIDs
+	}
+
+	if place.CreatedAt == nil {
+		place.CreatedAt = &now
+		if previous != nil && previous.CreatedAt != nil {
+			place.CreatedAt = previous.CreatedAt
+		}
+	}
+
+	if place.UpdatedAt == nil {
+		place.UpdatedAt = &now
+		if previous != nil && previous.ContentHash == hash && previous.UpdatedAt != nil {
+			place.UpdatedAt = previous.UpdatedAt
+		}
+	}
+}
+
+type existingDocument struct {
+	ContentHash string     `json:"content_hash"`
+	CreatedAt   *time.Time `json:"created_at"`
+	UpdatedAt   *time.Time `json:"updated_at"`
+
+	RatingAverage float64 `json:"rating_average"`
+	RatingCount   int     `json:"rating_count"`
+
+	MergedIDs []uint64 `json:"merged_ids"`
+}
+
+// documents which were in the index before the import
+type existingDocuments struct {
+	mutex     sync.Mutex
+	documents map[string]existingDocument
+	seen      map[string]bool
+	merged    map[string]string // ids of merged duplicates to ids of their canonical places
+}
+
+// returns the document with the given id and marks it as present in the new data
+func (documents *existingDocuments) visit(id string) (existingDocument, bool) {
+	documents.mutex.Lock()
+	defer documents.mutex.Unlock()
+
+	documents.seen[id] = true
+	existing, exists := documents.documents[id]
+
+	return existing, exists
+}
+
+// returns the document with the given id, nil if there is none
+func (documents *existingDocuments) lookup(id string) *existingDocument {
+	documents.mutex.Lock()
+	defer documents.mutex.Unlock()
+
+	if existing, exists := documents.documents[id]; exists {
+		return &existing
+	}
+	return nil
+}
+
+// Returns the canonical place the id was merged into by the dedup command.
+// The canonical place stands for the duplicate, so it's marked as present.
+func (documents *existingDocuments) mergedInto(id string) (string, bool) {
+	documents.mutex.Lock()
+	defer documents.mutex.Unlock()
+
+	canonical, merged := documents.merged[id]
+	if merged {
+		documents.seen[canonical] = true
+	}
+	return canonical, merged
+}
+
+func (documents *existingDocuments) count() int {
+	documents.mutex.Lock()
+	defer documents.mutex.Unlock()
+
+	return len(documents.documents)
+}
+
+// returns ids of documents which weren't visited during the import
+func (documents *existingDocuments) missing() []string {
+	documents.mutex.Lock()
+	defer documents.mutex.Unlock()
+
+	missing := make([]string, 0)
+	for id := range documents.documents {
+		if !documents.seen[id] {
+			missing = append(missing, id)
+		}
+	}
+
+	return missing
+}
+
+func loadExistingDocuments(client *elasticsearch.Client, index string) (*existingDocuments, error) {
+	documents := &existingDocuments{
+		documents: make(map[string]existingDocument),
+		seen:      make(map[string]bool),
+		merged:    make(map[string]string),
+	}
+
+	fields := []string{"content_hash", "created_at", "updated_at", "rating_average", "rating_count", "merged_ids"}
+	err := scrollDocuments(client, index, fields, func(id string, source json.RawMessage) error {
+		var document existingDocument
+		if err := json.Unmarshal(source, &document); err != nil {
+			return err
+		}
+
+		documents.documents[id] = document
+		for _, mergedID := range document.MergedIDs {
+			documents.merged[fmt.Sprint(mergedID)] = id
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return documents, nil
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"common"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+type boundingBox struct {
+	MinLongitude float64
+	MinLatitude  float64
+	MaxLongitude float64
+	MaxLatitude  float64
+}
+
+// parses "minLon,minLat,maxLon,maxLat", empty string means no box
+func parseBoundingBox(value string) (*boundingBox, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	parts := strings.Split(value, ",")
+	if len(parts) != 4 {
+		return nil, fmt.Errorf("bounding box must be \"minLon,minLat,maxLon,maxLat\", got %q", value)
+	}
+
+	coordinates := make([]float64, len(parts))
+	for i, part := range parts {
+		coordinate, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bounding box coordinate %q", part)
+		}
+		coordinates[i] = coordinate
+	}
+
+	box := &boundingBox{coordinates[0], coordinates[1], coordinates[2], coordinates[3]}
+	if box.MinLongitude > box.MaxLongitude || box.MinLatitude > box.MaxLatitude {
+		return nil, fmt.Errorf("bounding box minimum exceeds its maximum: %q", value)
+	}
+
+	return box, nil
+}
+
+func (box *boundingBox) contains(location common.Location) bool {
+	return location.Longitude >= box.MinLongitude && location.Longitude <= box.MaxLongitude &&
+		location.Latitude >= box.MinLatitude && location.Latitude <= box.MaxLatitude
+}
+
+type validationReport struct {
+	NormalizedPhones    uint64 `json:"normalized_phones"`
+	InvalidPhones       uint64 `json:"invalid_phones"`        // dropped, since they can't be converted to E.164
+	PlacesInvalidPhones uint64 `json:"places_invalid_phones"` // places which lost some of their phones
+	CollapsedWhitespace uint64 `json:"collapsed_whitespace"`
+	InvalidCoordinates  uint64 `json:"invalid_coordinates"`
+	OutsideBoundingBox  uint64 `json:"outside_bounding_box"`
+	DuplicatePlaces     uint64 `json:"duplicate_places"`
+}
+
+func (report validationReport) print(output io.Writer) {
+	fmt.Fprintf(
+		output,
+		"Normalized phones: %d\nDropped invalid phones: %d (of %d places)\nCollapsed whitespace: %d\nInvalid coordinates: %d\nOutside bounding box: %d\nDuplicate name and address: %d\n",
+		report.NormalizedPhones,
+		report.InvalidPhones,
+		report.PlacesInvalidPhones,
+		report.CollapsedWhitespace,
+		report.InvalidCoordinates,
+		report.OutsideBoundingBox,
+		report.DuplicatePlaces,
+	)
+}
+
+// Normalizes places before they are indexed and rejects the ones
+// which can't be fixed. Safe for concurrent use by import workers.
+type validator struct {
+	countryCode string
+	box         *boundingBox
+
+	mutex  sync.Mutex
+	places map[string]int // maps name and address to the line they were first seen on
+	report validationReport
+}
+
+func newValidator(countryCode string, box *boundingBox) *validator {
+	return &validator{
+		countryCode: strings.TrimPrefix(countryCode, "+"),
+		box:         box,
+		places:      make(map[string]int),
+	}
+}
+
+func collapseWhitespace(value string) string {
+	return strings.Join(strings.Fields(value), " ")
+}
+
+// Converts a phone to E.164. Numbers without a country code get the default one,
+// Russian trunk prefix 8 is replaced with 7.
+func (v *validator) normalizePhone(phone string) (string, bool) {
+	digits := strings.Map(func(r rune) rune {
+		if unicode.IsDigit(r) {
+			return r
+		}
+		return -1
+	}, phone)
+
+	switch {
+	case strings.HasPrefix(strings.TrimSpace(phone), "+"):
+	case len(digits) == 11 && (digits[0] == '8' || digits[0] == '7') && v.countryCode == "7":
+		digits = "7" + digits[1:]
+	case len(digits) == 10:
+		digits = v.countryCode + digits
+	default:
+		return "", false
+	}
+
+	// E.164 numbers are at most 15 digits long
+	if len(digits) < 8 || len(digits) > 15 {
+		return "", false
+	}
+
+	return "+" + digits, true
+}
+
+// Several phones of a place are separated by semicolons. Returns the valid ones
+// in E.164 along with the invalid ones, which are left out.
+func (v *validator) normalizePhones(phones string) (string, []string) {
+	normalized := make([]string, 0, 1)
+	invalid := make([]string, 0)
+
+	for _, phone := range strings.Split(phones, ";") {
+		if strings.TrimSpace(phone) == "" {
+			continue
+		}
+
+		if e164, ok := v.normalizePhone(phone); ok {
+			normalized = append(normalized, e164)
+		} else {
+			invalid = append(invalid, strings.TrimSpace(phone))
+		}
+	}
+
+	return strings.Join(normalized, ";"), invalid
+}
+
+func (v *validator) validate(place *common.Place, line int) error {
+	location := place.Location
+	if location.Validate() != nil {
+		v.count(&v.report.InvalidCoordinates)
+		return fmt.Errorf("coordinates lat=%v lon=%v are out of range", location.Latitude, location.Longitude)
+	}
+
+	if v.box != nil && !v.box.contains(location) {
+		v.count(&v.report.OutsideBoundingBox)
+		return fmt.Errorf("coordinates lat=%v lon=%v are outside of the bounding box", location.Latitude, location.Longitude)
+	}
+
+	name, address := collapseWhitespace(place.Name), collapseWhitespace(place.Address)
+	if name != place.Name || address != place.Address {
+		v.count(&v.report.CollapsedWhitespace)
+	}
+	place.Name, place.Address = name, address
+
+	// districts are filtered by exact value
+	place.District = collapseWhitespace(place.District)
+
+	if place.Phone != "" {
+		phone, invalid := v.normalizePhones(place.Phone)
+		if phone != "" && phone != place.Phone {
+			v.count(&v.report.NormalizedPhones)
+		}
+		if len(invalid) > 0 {
+			v.mutex.Lock()
+			v.report.InvalidPhones += uint64(len(invalid))
+			v.report.PlacesInvalidPhones++
+			v.mutex.Unlock()
+			log.Printf("Dropped invalid phones on line %d: %q\n", line, invalid)
+		}
+		place.Phone = phone
+	}
+
+	key := strings.ToLower(name) + "\x1f" + strings.ToLower(address)
+
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	if firstLine, exists := v.places[key]; exists {
+		v.report.DuplicatePlaces++
+		log.Printf("Place on line %d has the same name and address as the one on line %d\n", line, firstLine)
+	} else {
+		v.places[key] = line
+	}
+
+	return nil
+}
+
+func (v *validator) count(counter *uint64) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	*counter++
+}
+
+func (v *validator) Report() validationReport {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	return v.report
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"common"
+	"reflect"
+	"testing"
+)
+
+func TestNormalizePhone(t *testing.T) {
+	tests := []struct {
+		countryCode string
+		phone       string
+		want        string
+		ok          bool
+	}{
+		{countryCode: "7", phone: "(499) 183-14-10", want: "+74991831410", ok: true},
+		{countryCode: "7", phone: "8 (495) 676-55-35", want: "+74956765535", ok: true},
+		{countryCode: "7", phone: "7 495 676 55 35", want: "+74956765535", ok: true},
+		{countryCode: "7", phone: "+7 (495) 676-55-35", want: "+74956765535", ok: true},
+		{countryCode: "+49", phone: "3012345678", want: "+493012345678", ok: true},
+		{countryCode: "7", phone: "+44 20 7946 0958", want: "+442079460958", ok: true},
+		{countryCode: "49", phone: "8 (495) 676-55-35", ok: false},
+		{countryCode: "7", phone: "183-14-10", ok: false},
+		{countryCode: "7", phone: "+1234567", ok: false},
+		{countryCode: "7", phone: "+1234567890123456", ok: false},
+		{countryCode: "7", phone: "not a phone", ok: false},
+	}
+
+	for _, test := range tests {
+		v := newValidator(test.countryCode, nil)
+		got, ok := v.normalizePhone(test.phone)
+		if ok != test.ok || (ok && got != test.want) {
+			t.Errorf("normalizePhone(%q) with code %s = %q, %v, want %q, %v", test.phone, test.countryCode, got, ok, test.want, test.ok)
+		}
+	}
+}
+
+func TestValidateCountsDroppedPhones(t *testing.T) {
+	v := newValidator("7", nil)
+	places := []common.Place{
+		{Name: "First", Phone: "(499) 183-14-10;  ; 12-34", Location: common.Location{Latitude: 55.75, Longitude: 37.6}},
+		{Name: "Second", Phone: "+74956765535", Location: common.Location{Latitude: 55.75, Longitude: 37.6}},
+		{Name: "Third", Phone: "nope;no", Location: common.Location{Latitude: 55.75, Longitude: 37.6}},
+	}
+	wantPhones := []string{"+74991831410", "+74956765535", ""}
+
+	for i := range places {
+		if err := v.validate(&places[i], i+1); err != nil {
+			t.Fatal(err)
+		}
+		if places[i].Phone != wantPhones[i] {
+			t.Errorf("phone of %s = %q, want %q", places[i].Name, places[i].Phone, wantPhones[i])
+		}
+	}
+
+	report := v.Report()
+	want := validationReport{NormalizedPhones: 1, InvalidPhones: 3, PlacesInvalidPhones: 2}
+	if !reflect.DeepEqual(report, want) {
+		t.Errorf("report = %+v, want %+v", report, want)
+	}
+}
@@ -0,0 +1,246 @@
+package paginate
+
+import (
+	"common"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Narrows places down. A place matches a field if it has any of its values,
+// empty fields don't filter anything. Search matches words of the name or
+// the address, forgiving typos.
+type Filter struct {
+	Search     string
+	Cuisine    []string
+	PriceLevel []int
+	District   []string
+}
+
+// fields places can be filtered and faceted by
+var FacetFields = []string{"cuisine", "price_level", "district"}
+
+func (filter Filter) values(field string) []any {
+	var values []any
+	switch field {
+	case "cuisine":
+		for _, cuisine := range filter.Cuisine {
+			values = append(values, cuisine)
+		}
+	case "price_level":
+		for _, level := range filter.PriceLevel {
+			values = append(values, level)
+		}
+	case "district":
+		for _, district := range filter.District {
+			values = append(values, district)
+		}
+	}
+	return values
+}
+
+// bool query of the search and all the filters but the excluded field, nil if nothing is filtered
+func (filter Filter) query(excluded string) map[string]any {
+	clauses := make([]any, 0, len(FacetFields))
+	for _, field := range FacetFields {
+		if values := filter.values(field); field != excluded && len(values) > 0 {
+			clauses = append(clauses, map[string]any{"terms": map[string]any{field: values}})
+		}
+	}
+
+	if len(clauses) == 0 && filter.Search == "" {
+		return nil
+	}
+
+	query := map[string]any{"filter": clauses}
+	if filter.Search != "" {
+		query["must"] = map[string]any{"multi_match": map[string]any{
+			"query":     filter.Search,
+			"fields":    []string{"name", "address"},
+			"operator":  "and",
+			"fuzziness": "AUTO",
+		}}
+	}
+	return map[string]any{"bool": query}
+}
+
+type FacetBucket struct {
+	Value any `json:"value"`
+	Count int `json:"count"`
+}
+
+// number of the most frequent values returned per facet
+const facetSize = 50
+
+// Counts places per value of the fields. Each facet is narrowed down by filters
+// of the other fields only, so it lists alternatives to the selected values too.
+func (paginator *ElasticPaginator) GetFacets(filter Filter, fields []string) (map[string][]FacetBucket, error) {
+	aggregations := make(map[string]any, len(fields))
+	for _, field := range fields {
+		if !IsFacetField(field) {
+			return nil, fmt.Errorf("unknown facet %q, expected some of %q", field, FacetFields)
+		}
+
+		facetQuery := filter.query(field)
+		if facetQuery == nil {
+			facetQuery = map[string]any{"match_all": map[string]any{}}
+		}
+
+		aggregations[field] = map[string]any{
+			"filter": facetQuery,
+			"aggs": map[string]any{
+				"values": map[string]any{"terms": map[string]any{"field": field, "size": facetSize}},
+			},
+		}
+	}
+
+	body, err := json.Marshal(map[string]any{"size": 0, "aggs": aggregations})
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := paginator.Client.Search(
+		paginator.Client.Search.WithIndex(paginator.Index),
+		paginator.Client.Search.WithBody(strings.NewReader(string(body))),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.IsError() {
+		return nil, fmt.Errorf("%s", response)
+	}
+
+	var result struct {
+		Aggregations map[string]struct {
+			Values struct {
+				Buckets []struct {
+					Key      any `json:"key"`
+					DocCount int `json:"doc_count"`
+				} `json:"buckets"`
+			} `json:"values"`
+		} `json:"aggregations"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+
+	facets := make(map[string][]FacetBucket, len(fields))
+	for _, field := range fields {
+		buckets := result.Aggregations[field].Values.Buckets
+		facets[field] = make([]FacetBucket, len(buckets))
+		for i, bucket := range buckets {
+			facets[field][i] = FacetBucket{Value: bucket.Key, Count: bucket.DocCount}
+		}
+	}
+
+	return facets, nil
+}
+
+// values of the field the place has, in the form elasticsearch compares them
+func placeValues(place common.Place, field string) []any {
+	var values []any
+	switch field {
+	case "cuisine":
+		for _, cuisine := range place.Cuisine {
+			values = append(values, cuisine)
+		}
+	case "price_level":
+		if place.PriceLevel > 0 {
+			values = append(values, place.PriceLevel)
+		}
+	case "district":
+		if place.District != "" {
+			values = append(values, place.District)
+		}
+	}
+	return values
+}
+
+// whether the place has any of the filtered values of the field, true if the field isn't filtered
+func (filter Filter) matchesField(place common.Place, field string) bool {
+	filtered := filter.values(field)
+	if len(filtered) == 0 {
+		return true
+	}
+
+	for _, value := range placeValues(place, field) {
+		for _, wanted := range filtered {
+			if value == wanted {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// whether the place passes filters of all the fields but the excluded one, the search aside
+func (filter Filter) matches(place common.Place, excluded string) bool {
+	for _, field := range FacetFields {
+		if field != excluded && !filter.matchesField(place, field) {
+			return false
+		}
+	}
+	return true
+}
+
+// Narrows places found by the search alone down by the other filters. Along with
+// CountFacets it replaces GetFilteredPlaces and GetFacets when places are narrowed
+// down further in memory, e.g. by opening hours, which elasticsearch can't do.
+func (filter Filter) Apply(places []common.Place) []common.Place {
+	matching := make([]common.Place, 0, len(places))
+	for _, place := range places {
+		if filter.matches(place, "") {
+			matching = append(matching, place)
+		}
+	}
+	return matching
+}
+
+// Counts places found by the search alone the same way GetFacets does, each
+// facet is narrowed down by filters of the other fields only.
+func (filter Filter) CountFacets(places []common.Place, fields []string) map[string][]FacetBucket {
+	facets := make(map[string][]FacetBucket, len(fields))
+	for _, field := range fields {
+		counts := make(map[any]int)
+		for _, place := range places {
+			if !filter.matches(place, field) {
+				continue
+			}
+			for _, value := range placeValues(place, field) {
+				counts[value]++
+			}
+		}
+
+		buckets := make([]FacetBucket, 0, len(counts))
+		for value, count := range counts {
+			buckets = append(buckets, FacetBucket{Value: value, Count: count})
+		}
+
+		// the order of terms aggregations, the most frequent values first
+		sort.Slice(buckets, func(i, j int) bool {
+			if buckets[i].Count != buckets[j].Count {
+				return buckets[i].Count > buckets[j].Count
+			}
+			return fmt.Sprint(buckets[i].Value) < fmt.Sprint(buckets[j].Value)
+		})
+		if len(buckets) > facetSize {
+			buckets = buckets[:facetSize]
+		}
+
+		facets[field] = buckets
+	}
+
+	return facets
+}
+
+func IsFacetField(field string) bool {
+	for _, known := range FacetFields {
+		if field == known {
+			return true
+		}
+	}
+	return false
+}
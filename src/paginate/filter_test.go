@@ -0,0 +1,70 @@
+package paginate
+
+import (
+	"common"
+	"reflect"
+	"testing"
+)
+
+func TestFilterCountFacets(t *testing.T) {
+	places := []common.Place{
+		{ID: 1, Cuisine: []string{"italian", "pizza"}, PriceLevel: 2, District: "center"},
+		{ID: 2, Cuisine: []string{"italian"}, PriceLevel: 3, District: "center"},
+		{ID: 3, Cuisine: []string{"georgian"}, PriceLevel: 2, District: "north"},
+		{ID: 4, Cuisine: []string{"georgian"}},
+	}
+
+	tests := []struct {
+		name    string
+		filter  Filter
+		fields  []string
+		matched []uint64
+		facets  map[string][]FacetBucket
+	}{
+		{
+			name:    "nothing filtered",
+			fields:  []string{"cuisine", "price_level"},
+			matched: []uint64{1, 2, 3, 4},
+			facets: map[string][]FacetBucket{
+				"cuisine":     {{Value: "georgian", Count: 2}, {Value: "italian", Count: 2}, {Value: "pizza", Count: 1}},
+				"price_level": {{Value: 2, Count: 2}, {Value: 3, Count: 1}},
+			},
+		},
+		{
+			// the selected cuisine still lists the other cuisines, while the district narrows it down
+			name:    "facet of a filtered field lists alternatives",
+			filter:  Filter{Cuisine: []string{"italian"}, District: []string{"center"}},
+			fields:  []string{"cuisine", "district"},
+			matched: []uint64{1, 2},
+			facets: map[string][]FacetBucket{
+				"cuisine":  {{Value: "italian", Count: 2}, {Value: "pizza", Count: 1}},
+				"district": {{Value: "center", Count: 2}},
+			},
+		},
+		{
+			name:    "several values of a field",
+			filter:  Filter{PriceLevel: []int{2, 3}, Cuisine: []string{"georgian"}},
+			fields:  []string{"price_level"},
+			matched: []uint64{3},
+			facets: map[string][]FacetBucket{
+				"price_level": {{Value: 2, Count: 1}},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			matched := make([]uint64, 0)
+			for _, place := range test.filter.Apply(places) {
+				matched = append(matched, place.ID)
+			}
+			if !reflect.DeepEqual(matched, test.matched) {
+				t.Errorf("Apply = %v, want %v", matched, test.matched)
+			}
+
+			if facets := test.filter.CountFacets(places, test.fields); !reflect.DeepEqual(facets, test.facets) {
+				t.Errorf("CountFacets = %v, want %v", facets, test.facets)
+			}
+		})
+	}
+}
@@ -0,0 +1,346 @@
+package paginate
+
+import (
+	"common"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+)
+
+// Keeps lists of places of users. Lists are always looked up
+// by their user, so nobody can reach lists of somebody else.
+type ListStore interface {
+	// returns lists of the user from the oldest to the newest one
+	GetLists(user string) ([]common.PlaceList, error)
+
+	// returns nil if the user has no such list
+	GetList(user, id string) (*common.PlaceList, error)
+
+	CreateList(list common.PlaceList) error
+
+	// methods below return false if the user has no such list,
+	// except for favorites, which are created by adding a place to them
+	RenameList(user, id, name string) (bool, error)
+	DeleteList(user, id string) (bool, error)
+	AddPlace(user, id string, placeID uint64) (bool, error)
+	RemovePlace(user, id string, placeID uint64) (bool, error)
+}
+
+const listsMapping = `
+{
+	"mappings": {
+		"properties": {
+			"id": {
+				"type": "keyword"
+			},
+			"user": {
+				"type": "keyword"
+			},
+			"name": {
+				"type": "text"
+			},
+			"place_ids": {
+				"type": "long"
+			},
+			"created_at": {
+				"type": "date"
+			},
+			"updated_at": {
+				"type": "date"
+			}
+		}
+	}
+}`
+
+// Places are compared as numbers, since small ids are decoded
+// as integers and large ones as longs.
+const (
+	addPlaceScript = `
+		if (ctx._source.place_ids == null) {
+			ctx._source.place_ids = [];
+		}
+		for (def id : ctx._source.place_ids) {
+			if (((Number) id).longValue() == ((Number) params.place_id).longValue()) {
+				ctx.op = 'noop';
+				return;
+			}
+		}
+		ctx._source.place_ids.add(params.place_id);
+		ctx._source.updated_at = params.now;`
+
+	removePlaceScript = `
+		if (ctx._source.place_ids == null || !ctx._source.place_ids.removeIf(id -> ((Number) id).longValue() == ((Number) params.place_id).longValue())) {
+			ctx.op = 'noop';
+			return;
+		}
+		ctx._source.updated_at = params.now;`
+)
+
+type ElasticLists struct {
+	Client *elasticsearch.Client
+	Index  string
+}
+
+// creates the lists index unless it exists
+func (lists *ElasticLists) EnsureIndex() error {
+	return ensureIndex(lists.Client, lists.Index, listsMapping)
+}
+
+// Document of a list is named by its id up to the first "_" followed by the user,
+// whose name may contain "_" too. Ids with "_" are refused, otherwise the list
+// "favorites_a" of "bob" would be the favorites of "a_bob".
+func listDocumentID(user, id string) (string, bool) {
+	if id == "" || strings.Contains(id, "_") {
+		return "", false
+	}
+	return fmt.Sprintf("%s_%s", id, user), true
+}
+
+func (lists *ElasticLists) GetLists(user string) ([]common.PlaceList, error) {
+	body, _ := json.Marshal(map[string]any{
+		"size":  10_000,
+		"query": map[string]any{"term": map[string]any{"user": user}},
+		"sort":  []any{map[string]string{"created_at": "asc"}},
+	})
+
+	response, err := lists.Client.Search(
+		lists.Client.Search.WithIndex(lists.Index),
+		lists.Client.Search.WithBody(strings.NewReader(string(body))),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.IsError() {
+		return nil, fmt.Errorf("%s", response)
+	}
+
+	var result struct {
+		Hits struct {
+			Hits []struct {
+				Source common.PlaceList `json:"_source"`
+			} `json:"hits"`
+		} `json:"hits"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+
+	found := make([]common.PlaceList, len(result.Hits.Hits))
+	for i, hit := range result.Hits.Hits {
+		found[i] = hit.Source
+	}
+
+	return found, nil
+}
+
+// document of a list along with its version, which mutations are conditioned on
+type listDocument struct {
+	Source      common.PlaceList `json:"_source"`
+	SeqNo       int              `json:"_seq_no"`
+	PrimaryTerm int              `json:"_primary_term"`
+}
+
+// documents written before ids were checked may belong to somebody else
+func (document *listDocument) ownedBy(user, id string) bool {
+	return document.Source.User == user && document.Source.ID == id
+}
+
+// returns nil if there is no such document
+func (lists *ElasticLists) getDocument(documentID string) (*listDocument, error) {
+	response, err := lists.Client.Get(lists.Index, documentID)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if response.IsError() {
+		return nil, fmt.Errorf("%s", response)
+	}
+
+	var document listDocument
+	if err := json.NewDecoder(response.Body).Decode(&document); err != nil {
+		return nil, err
+	}
+
+	return &document, nil
+}
+
+func (lists *ElasticLists) GetList(user, id string) (*common.PlaceList, error) {
+	documentID, ok := listDocumentID(user, id)
+	if !ok {
+		return nil, nil
+	}
+
+	document, err := lists.getDocument(documentID)
+	if err != nil || document == nil || !document.ownedBy(user, id) {
+		return nil, err
+	}
+
+	return &document.Source, nil
+}
+
+func (lists *ElasticLists) CreateList(list common.PlaceList) error {
+	if list.PlaceIDs == nil {
+		list.PlaceIDs = make([]uint64, 0)
+	}
+
+	documentID, ok := listDocumentID(list.User, list.ID)
+	if !ok {
+		return fmt.Errorf("invalid list id %q", list.ID)
+	}
+
+	marshalizedList, err := json.Marshal(list)
+	if err != nil {
+		return err
+	}
+
+	// changes of lists are visible to the next request of the user
+	response, err := lists.Client.Create(
+		lists.Index,
+		documentID,
+		strings.NewReader(string(marshalizedList)),
+		lists.Client.Create.WithRefresh("wait_for"),
+	)
+	return checkResponse(response, err)
+}
+
+// the list changed by another request between the check of its owner and the change is checked again
+const maxListConflicts = 3
+
+// Changes the list after the same check of its owner as GetList. The change applies only to the
+// version of the document which was checked, so the document can't be replaced in between.
+// Missing lists are created if create is set, otherwise false is returned for them.
+func (lists *ElasticLists) mutate(
+	user, id string,
+	create func(documentID string) (*esapi.Response, error),
+	change func(documentID string, document *listDocument) (*esapi.Response, error),
+) (bool, error) {
+	documentID, ok := listDocumentID(user, id)
+	if !ok {
+		return false, nil
+	}
+
+	for attempt := 1; ; attempt++ {
+		document, err := lists.getDocument(documentID)
+		if err != nil {
+			return false, err
+		}
+
+		var response *esapi.Response
+		switch {
+		case document == nil && create == nil:
+			return false, nil
+		case document == nil:
+			response, err = create(documentID)
+		case !document.ownedBy(user, id):
+			return false, nil
+		default:
+			response, err = change(documentID, document)
+		}
+		if err != nil {
+			return false, err
+		}
+		response.Body.Close()
+
+		switch {
+		case response.StatusCode == http.StatusConflict && attempt < maxListConflicts:
+			continue
+		case response.StatusCode == http.StatusNotFound:
+			// deleted since it was checked
+			return false, nil
+		case response.IsError():
+			return false, fmt.Errorf("%s", response)
+		}
+
+		return true, nil
+	}
+}
+
+// updates the checked version of the document
+func (lists *ElasticLists) update(body map[string]any) func(string, *listDocument) (*esapi.Response, error) {
+	return func(documentID string, document *listDocument) (*esapi.Response, error) {
+		marshalizedBody, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+
+		return lists.Client.Update(
+			lists.Index,
+			documentID,
+			strings.NewReader(string(marshalizedBody)),
+			lists.Client.Update.WithRefresh("wait_for"),
+			lists.Client.Update.WithIfSeqNo(document.SeqNo),
+			lists.Client.Update.WithIfPrimaryTerm(document.PrimaryTerm),
+		)
+	}
+}
+
+func (lists *ElasticLists) RenameList(user, id, name string) (bool, error) {
+	return lists.mutate(user, id, nil, lists.update(map[string]any{"doc": map[string]any{
+		"name":       name,
+		"updated_at": time.Now().UTC(),
+	}}))
+}
+
+func (lists *ElasticLists) DeleteList(user, id string) (bool, error) {
+	return lists.mutate(user, id, nil, func(documentID string, document *listDocument) (*esapi.Response, error) {
+		return lists.Client.Delete(
+			lists.Index,
+			documentID,
+			lists.Client.Delete.WithRefresh("wait_for"),
+			lists.Client.Delete.WithIfSeqNo(document.SeqNo),
+			lists.Client.Delete.WithIfPrimaryTerm(document.PrimaryTerm),
+		)
+	})
+}
+
+func (lists *ElasticLists) AddPlace(user, id string, placeID uint64) (bool, error) {
+	now := time.Now().UTC()
+
+	// favorites are created by adding the first place to them
+	var create func(string) (*esapi.Response, error)
+	if id == common.FavoritesListID {
+		create = func(documentID string) (*esapi.Response, error) {
+			marshalizedList, err := json.Marshal(common.PlaceList{
+				ID:        id,
+				User:      user,
+				Name:      "Favorites",
+				PlaceIDs:  []uint64{placeID},
+				CreatedAt: now,
+				UpdatedAt: now,
+			})
+			if err != nil {
+				return nil, err
+			}
+
+			return lists.Client.Create(
+				lists.Index,
+				documentID,
+				strings.NewReader(string(marshalizedList)),
+				lists.Client.Create.WithRefresh("wait_for"),
+			)
+		}
+	}
+
+	return lists.mutate(user, id, create, lists.update(map[string]any{"script": map[string]any{
+		"source": addPlaceScript,
+		"params": map[string]any{"place_id": placeID, "now": now},
+	}}))
+}
+
+func (lists *ElasticLists) RemovePlace(user, id string, placeID uint64) (bool, error) {
+	return lists.mutate(user, id, nil, lists.update(map[string]any{"script": map[string]any{
+		"source": removePlaceScript,
+		"params": map[string]any{"place_id": placeID, "now": time.Now().UTC()},
+	}}))
+}
@@ -0,0 +1,191 @@
+package paginate
+
+import (
+	"common"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/elastic/go-elasticsearch/v8"
+)
+
+func TestListDocumentID(t *testing.T) {
+	tests := []struct {
+		user string
+		id   string
+		want string
+		ok   bool
+	}{
+		{user: "bob", id: "favorites", want: "favorites_bob", ok: true},
+		{user: "a_bob", id: "favorites", want: "favorites_a_bob", ok: true},
+		{user: "bob", id: "0123456789abcdef", want: "0123456789abcdef_bob", ok: true},
+		{user: "bob", id: "favorites_a", ok: false},
+		{user: "bob", id: "", ok: false},
+	}
+
+	for _, test := range tests {
+		got, ok := listDocumentID(test.user, test.id)
+		if got != test.want || ok != test.ok {
+			t.Errorf("listDocumentID(%q, %q) = %q, %v, want %q, %v", test.user, test.id, got, ok, test.want, test.ok)
+		}
+	}
+}
+
+// Elasticsearch keeping list documents, which records every request changing them
+type fakeLists struct {
+	documents map[string]common.PlaceList
+	writes    []string
+}
+
+func (fake *fakeLists) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("X-Elastic-Product", "Elasticsearch")
+	w.Header().Set("Content-Type", "application/json")
+
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	documentID := parts[len(parts)-1]
+	document, exists := fake.documents[documentID]
+
+	if r.Method == http.MethodGet {
+		if !exists {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"found": false}`))
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{"found": true, "_seq_no": 5, "_primary_term": 1, "_source": document})
+		return
+	}
+
+	fake.writes = append(fake.writes, fmt.Sprintf("%s %s?%s", r.Method, r.URL.Path, r.URL.RawQuery))
+	switch {
+	case parts[1] == "_create" && exists:
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte(`{"error": {"type": "version_conflict_engine_exception"}, "status": 409}`))
+	case parts[1] != "_create" && !exists:
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"result": "not_found"}`))
+	default:
+		w.Write([]byte(`{"result": "updated"}`))
+	}
+}
+
+func newFakeLists(t *testing.T, documents map[string]common.PlaceList) (*fakeLists, *ElasticLists) {
+	fake := &fakeLists{documents: documents}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	client, err := elasticsearch.NewClient(elasticsearch.Config{Addresses: []string{server.URL}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fake, &ElasticLists{Client: client, Index: "lists"}
+}
+
+// lists of "a_bob" can't be reached by "bob", neither by a crafted id nor through an old document
+func TestGetListOfAnotherUser(t *testing.T) {
+	_, lists := newFakeLists(t, map[string]common.PlaceList{
+		"favorites_a_bob": {ID: "favorites", User: "a_bob", Name: "Favorites"},
+		"favorites_bob":   {ID: "favorites_a", User: "bob", Name: "Favorites"},
+	})
+
+	tests := []struct {
+		user  string
+		id    string
+		found bool
+	}{
+		{user: "a_bob", id: "favorites", found: true},
+		{user: "bob", id: "favorites_a", found: false},
+		{user: "bob", id: "favorites", found: false},
+	}
+
+	for _, test := range tests {
+		list, err := lists.GetList(test.user, test.id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (list != nil) != test.found {
+			t.Errorf("GetList(%q, %q) = %+v, want found %v", test.user, test.id, list, test.found)
+		}
+	}
+}
+
+// lists are changed only after the same check of their owner as GetList,
+// and only the version of the document which was checked
+func TestMutationsCheckOwner(t *testing.T) {
+	tests := []struct {
+		name   string
+		mutate func(lists *ElasticLists) (bool, error)
+		found  bool
+		write  string
+	}{
+		{
+			name:   "rename own list",
+			mutate: func(lists *ElasticLists) (bool, error) { return lists.RenameList("a_bob", "favorites", "Best") },
+			found:  true,
+			write:  "POST /lists/_update/favorites_a_bob?if_primary_term=1&if_seq_no=5&refresh=wait_for",
+		},
+		{
+			name:   "rename by crafted id",
+			mutate: func(lists *ElasticLists) (bool, error) { return lists.RenameList("bob", "favorites_a", "Mine") },
+		},
+		{
+			name:   "rename old document of another list",
+			mutate: func(lists *ElasticLists) (bool, error) { return lists.RenameList("bob", "favorites", "Mine") },
+		},
+		{
+			name:   "delete own list",
+			mutate: func(lists *ElasticLists) (bool, error) { return lists.DeleteList("a_bob", "favorites") },
+			found:  true,
+			write:  "DELETE /lists/_doc/favorites_a_bob?if_primary_term=1&if_seq_no=5&refresh=wait_for",
+		},
+		{
+			name:   "delete old document of another list",
+			mutate: func(lists *ElasticLists) (bool, error) { return lists.DeleteList("bob", "favorites") },
+		},
+		{
+			name:   "add to old document of another list",
+			mutate: func(lists *ElasticLists) (bool, error) { return lists.AddPlace("bob", "favorites", 1) },
+		},
+		{
+			name:   "remove from old document of another list",
+			mutate: func(lists *ElasticLists) (bool, error) { return lists.RemovePlace("bob", "favorites", 1) },
+		},
+		{
+			name:   "add to missing favorites",
+			mutate: func(lists *ElasticLists) (bool, error) { return lists.AddPlace("carol", "favorites", 1) },
+			found:  true,
+			write:  "PUT /lists/_create/favorites_carol?refresh=wait_for",
+		},
+		{
+			name:   "add to missing list",
+			mutate: func(lists *ElasticLists) (bool, error) { return lists.AddPlace("carol", "lunch", 1) },
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fake, lists := newFakeLists(t, map[string]common.PlaceList{
+				"favorites_a_bob": {ID: "favorites", User: "a_bob", Name: "Favorites"},
+				"favorites_bob":   {ID: "favorites_a", User: "bob", Name: "Favorites"},
+			})
+
+			found, err := test.mutate(lists)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if found != test.found {
+				t.Errorf("found = %v, want %v", found, test.found)
+			}
+
+			var want []string
+			if test.write != "" {
+				want = []string{test.write}
+			}
+			if strings.Join(fake.writes, "\n") != strings.Join(want, "\n") {
+				t.Errorf("writes = %q, want %q", fake.writes, want)
+			}
+		})
+	}
+}
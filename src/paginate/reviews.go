@@ -0,0 +1,300 @@
+package paginate
+
+import (
+	"common"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+)
+
+const reviewsMapping = `
+{
+	"mappings": {
+		"properties": {
+			"place_id": {
+				"type": "long"
+			},
+			"user": {
+				"type": "keyword"
+			},
+			"rating": {
+				"type": "byte"
+			},
+			"text": {
+				"type": "text"
+			},
+			"created_at": {
+				"type": "date"
+			}
+		}
+	}
+}`
+
+// Reviews are stored in their own index, while the average
+// rating and the number of reviews are copied to the place.
+type ElasticReviews struct {
+	Client      *elasticsearch.Client
+	Index       string
+	PlacesIndex string
+}
+
+// creates the reviews index unless it exists
+func (reviews *ElasticReviews) EnsureIndex() error {
+	return ensureIndex(reviews.Client, reviews.Index, reviewsMapping)
+}
+
+func ensureIndex(client *elasticsearch.Client, index, mapping string) error {
+	exists, err := client.Indices.Exists([]string{index})
+	if err != nil {
+		return err
+	}
+	exists.Body.Close()
+	if exists.StatusCode == http.StatusOK {
+		return nil
+	}
+
+	response, err := client.Indices.Create(
+		index,
+		client.Indices.Create.WithBody(strings.NewReader(mapping)),
+	)
+	return checkResponse(response, err)
+}
+
+// the user is part of the id, so a new review of the same user replaces the previous one
+func reviewID(placeID uint64, user string) string {
+	return fmt.Sprintf("%d_%s", placeID, user)
+}
+
+// stores the review and recounts the rating of its place
+func (reviews *ElasticReviews) AddReview(review common.Review) error {
+	marshalizedReview, err := json.Marshal(review)
+	if err != nil {
+		return err
+	}
+
+	// the review must be searchable before the rating is recounted
+	response, err := reviews.Client.Index(
+		reviews.Index,
+		strings.NewReader(string(marshalizedReview)),
+		reviews.Client.Index.WithDocumentID(reviewID(review.PlaceID, review.User)),
+		reviews.Client.Index.WithRefresh("wait_for"),
+	)
+	if err := checkResponse(response, err); err != nil {
+		return err
+	}
+
+	return reviews.updateRating(review.PlaceID)
+}
+
+func (reviews *ElasticReviews) updateRating(placeID uint64) error {
+	body := fmt.Sprintf(`
+	{
+		"size": 0,
+		"query": {"term": {"place_id": %d}},
+		"aggs": {"rating": {"stats": {"field": "rating"}}}
+	}`, placeID)
+
+	response, err := reviews.Client.Search(
+		reviews.Client.Search.WithIndex(reviews.Index),
+		reviews.Client.Search.WithBody(strings.NewReader(body)),
+	)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.IsError() {
+		return fmt.Errorf("%s", response)
+	}
+
+	var result struct {
+		Aggregations struct {
+			Rating struct {
+				Count   int     `json:"count"`
+				Average float64 `json:"avg"`
+			} `json:"rating"`
+		} `json:"aggregations"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&result); err != nil {
+		return err
+	}
+
+	update, _ := json.Marshal(map[string]any{"doc": map[string]any{
+		"rating_average": result.Aggregations.Rating.Average,
+		"rating_count":   result.Aggregations.Rating.Count,
+	}})
+
+	// retried in case the place is being updated by an import at the same time
+	updateResponse, err := reviews.Client.Update(
+		reviews.PlacesIndex,
+		fmt.Sprint(placeID),
+		strings.NewReader(string(update)),
+		reviews.Client.Update.WithRetryOnConflict(3),
+	)
+	return checkResponse(updateResponse, err)
+}
+
+// Moves reviews of places merged into another place to that place and recounts
+// its rating. A user who has reviewed several of the places keeps only the newest
+// review, since every user has at most one review of a place.
+func (reviews *ElasticReviews) MoveReviews(fromIDs []uint64, toID uint64) error {
+	body, _ := json.Marshal(map[string]any{
+		"size":  10_000,
+		"query": map[string]any{"terms": map[string]any{"place_id": append(append([]uint64{}, fromIDs...), toID)}},
+		"sort":  []any{map[string]string{"created_at": "desc"}},
+	})
+
+	response, err := reviews.Client.Search(
+		reviews.Client.Search.WithIndex(reviews.Index),
+		reviews.Client.Search.WithBody(strings.NewReader(string(body))),
+	)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.IsError() {
+		return fmt.Errorf("%s", response)
+	}
+
+	var result struct {
+		Hits struct {
+			Hits []struct {
+				ID     string        `json:"_id"`
+				Source common.Review `json:"_source"`
+			} `json:"hits"`
+		} `json:"hits"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&result); err != nil {
+		return err
+	}
+
+	var bulk strings.Builder
+	kept := make(map[string]bool)
+	for _, hit := range result.Hits.Hits {
+		review := hit.Source
+		if review.PlaceID == toID {
+			kept[review.User] = true
+			continue
+		}
+
+		// reviews are sorted from the newest, so an older one of the same user is dropped
+		fmt.Fprintf(&bulk, "{\"delete\": {\"_id\": %q}}\n", hit.ID)
+		if kept[review.User] {
+			continue
+		}
+		kept[review.User] = true
+
+		review.PlaceID = toID
+		marshalizedReview, err := json.Marshal(review)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&bulk, "{\"index\": {\"_id\": %q}}\n%s\n", reviewID(toID, review.User), marshalizedReview)
+	}
+
+	if bulk.Len() > 0 {
+		// reviews must be searchable before the rating is recounted
+		bulkResponse, err := reviews.Client.Bulk(
+			strings.NewReader(bulk.String()),
+			reviews.Client.Bulk.WithIndex(reviews.Index),
+			reviews.Client.Bulk.WithRefresh("wait_for"),
+		)
+		if err != nil {
+			return err
+		}
+		defer bulkResponse.Body.Close()
+
+		if bulkResponse.IsError() {
+			return fmt.Errorf("%s", bulkResponse)
+		}
+
+		var bulkResult struct {
+			Errors bool `json:"errors"`
+			Items  []map[string]struct {
+				ID    string          `json:"_id"`
+				Error json.RawMessage `json:"error"`
+			} `json:"items"`
+		}
+		if err := json.NewDecoder(bulkResponse.Body).Decode(&bulkResult); err != nil {
+			return err
+		}
+		if bulkResult.Errors {
+			for _, item := range bulkResult.Items {
+				for action, outcome := range item {
+					if outcome.Error != nil {
+						return fmt.Errorf("couldn't %s review %s: %s", action, outcome.ID, outcome.Error)
+					}
+				}
+			}
+		}
+	}
+
+	return reviews.updateRating(toID)
+}
+
+// returns reviews of the place from the newest to the oldest along with their total number
+func (reviews *ElasticReviews) GetReviews(placeID uint64, limit int, offset int) ([]common.Review, int, error) {
+	if limit < 0 || offset < 0 {
+		return nil, 0, fmt.Errorf("negative limit or offset is not allowed")
+	}
+
+	body := fmt.Sprintf(`
+	{
+		"from": %d,
+		"size": %d,
+		"query": {"term": {"place_id": %d}},
+		"sort": [{"created_at": "desc"}]
+	}`, offset, limit, placeID)
+
+	response, err := reviews.Client.Search(
+		reviews.Client.Search.WithIndex(reviews.Index),
+		reviews.Client.Search.WithBody(strings.NewReader(body)),
+	)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer response.Body.Close()
+
+	if response.IsError() {
+		return nil, 0, fmt.Errorf("%s", response)
+	}
+
+	var result struct {
+		Hits struct {
+			Total struct {
+				Value int `json:"value"`
+			} `json:"total"`
+
+			Hits []struct {
+				Source common.Review `json:"_source"`
+			} `json:"hits"`
+		} `json:"hits"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&result); err != nil {
+		return nil, 0, err
+	}
+
+	found := make([]common.Review, len(result.Hits.Hits))
+	for i, hit := range result.Hits.Hits {
+		found[i] = hit.Source
+	}
+
+	return found, result.Hits.Total.Value, nil
+}
+
+func checkResponse(response *esapi.Response, err error) error {
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.IsError() {
+		return fmt.Errorf("%s", response)
+	}
+	return nil
+}
@@ -4,6 +4,7 @@ import (
 	"common"
 	"encoding/json"
 	"fmt"
+	"net/http"
 	"strings"
 
 	"github.com/elastic/go-elasticsearch/v8"
@@ -13,6 +14,12 @@ type Store interface {
 	// returns a list of items,
 	// a total number of hits and (or) an error in case of one
 	GetPlaces(limit int, offset int) ([]common.Place, int, error)
+
+	// same as GetPlaces, but only for places matching the filter
+	GetFilteredPlaces(limit int, offset int, filter Filter) ([]common.Place, int, error)
+
+	// returns counts of places per value of each field
+	GetFacets(filter Filter, fields []string) (map[string][]FacetBucket, error)
 }
 
 type ElasticPaginator struct {
@@ -40,7 +47,7 @@ const query = `
 	"size": %d,
 	"sort": [
 		%s
-	]%s
+	]%s%s
 }`
 
 type SortParameter struct {
@@ -48,7 +55,7 @@ type SortParameter struct {
 	Descending bool
 }
 
-func buildQuery(limit int, searchAfter []any, params []SortParameter) (string, error) {
+func buildQuery(limit int, searchAfter []any, params []SortParameter, filter Filter) (string, error) {
 	if limit < 0 {
 		return "", fmt.Errorf("negative limit is not allowed")
 	}
@@ -69,6 +76,17 @@ func buildQuery(limit int, searchAfter []any, params []SortParameter) (string, e
 		sorts[i] = fmt.Sprintf("{%q: %q}", param.Field, sort)
 	}
 
+	var filterQuery string
+	if filterClause := filter.query(""); filterClause != nil {
+		marshalizedFilter, err := json.Marshal(filterClause)
+		if err != nil {
+			return "", err
+		}
+		filterQuery = fmt.Sprintf(`
+	,
+	"query": %s`, marshalizedFilter)
+	}
+
 	if len(searchAfter) > 0 {
 		searchAfterStringValues := make([]string, len(searchAfter))
 		for i, v := range searchAfter {
@@ -84,6 +102,7 @@ func buildQuery(limit int, searchAfter []any, params []SortParameter) (string, e
 			query,
 			limit,
 			strings.Join(sorts, ","),
+			filterQuery,
 			fmt.Sprintf(`
 			,
 			"search_after": [
@@ -92,10 +111,14 @@ func buildQuery(limit int, searchAfter []any, params []SortParameter) (string, e
 				strings.Join(searchAfterStringValues, ", ")),
 		), nil
 	}
-	return fmt.Sprintf(query, limit, strings.Join(sorts, ","), ""), nil
+	return fmt.Sprintf(query, limit, strings.Join(sorts, ","), filterQuery, ""), nil
 }
 
 func (paginator *ElasticPaginator) GetPlaces(limit int, offset int) ([]common.Place, int, error) {
+	return paginator.GetFilteredPlaces(limit, offset, Filter{})
+}
+
+func (paginator *ElasticPaginator) GetFilteredPlaces(limit int, offset int, filter Filter) ([]common.Place, int, error) {
 	if offset < 0 {
 		return nil, 0, fmt.Errorf("offset can not be less than 0")
 	}
@@ -114,6 +137,7 @@ func (paginator *ElasticPaginator) GetPlaces(limit int, offset int) ([]common.Pl
 				{Field: "id", Descending: false},
 				{Field: "_score", Descending: true},
 			},
+			filter,
 		)
 		if err != nil {
 			return nil, 0, err
@@ -163,3 +187,105 @@ func (paginator *ElasticPaginator) GetPlaces(limit int, offset int) ([]common.Pl
 	places = places[offset : offset+limit]
 	return places, len(places), nil
 }
+
+// returns nil if there is no place with the id
+func (paginator *ElasticPaginator) GetPlace(id uint64) (*common.Place, error) {
+	response, err := paginator.Client.Get(paginator.Index, fmt.Sprint(id))
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if response.IsError() {
+		return nil, fmt.Errorf("%s", response)
+	}
+
+	var result struct {
+		Source common.Place `json:"_source"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+
+	return &result.Source, nil
+}
+
+// Returns the place the duplicate with the id was merged into,
+// nil if no place has merged it.
+func (paginator *ElasticPaginator) GetCanonicalPlace(mergedID uint64) (*common.Place, error) {
+	body, _ := json.Marshal(map[string]any{
+		"size":  1,
+		"query": map[string]any{"term": map[string]any{"merged_ids": mergedID}},
+	})
+
+	response, err := paginator.Client.Search(
+		paginator.Client.Search.WithIndex(paginator.Index),
+		paginator.Client.Search.WithBody(strings.NewReader(string(body))),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.IsError() {
+		return nil, fmt.Errorf("%s", response)
+	}
+
+	var result ElasticSortResponse
+	if err := json.NewDecoder(response.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+
+	if len(result.Hits.Hits) == 0 {
+		return nil, nil
+	}
+	return &result.Hits.Hits[0].Source, nil
+}
+
+// returns places in the order of ids, ids of places which no longer exist are skipped
+func (paginator *ElasticPaginator) GetPlacesByIDs(ids []uint64) ([]common.Place, error) {
+	places := make([]common.Place, 0, len(ids))
+	if len(ids) == 0 {
+		return places, nil
+	}
+
+	documentIDs := make([]string, len(ids))
+	for i, id := range ids {
+		documentIDs[i] = fmt.Sprint(id)
+	}
+	body, _ := json.Marshal(map[string]any{"ids": documentIDs})
+
+	response, err := paginator.Client.Mget(
+		strings.NewReader(string(body)),
+		paginator.Client.Mget.WithIndex(paginator.Index),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.IsError() {
+		return nil, fmt.Errorf("%s", response)
+	}
+
+	var result struct {
+		Docs []struct {
+			Found  bool         `json:"found"`
+			Source common.Place `json:"_source"`
+		} `json:"docs"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+
+	for _, document := range result.Docs {
+		if document.Found {
+			places = append(places, document.Source)
+		}
+	}
+
+	return places, nil
+}
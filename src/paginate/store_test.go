@@ -0,0 +1,79 @@
+package paginate
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/elastic/go-elasticsearch/v8"
+)
+
+// places are read from the index or alias the api is configured with, whatever its name
+func TestPaginatorReadsConfiguredIndex(t *testing.T) {
+	var requested []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Elastic-Product", "Elasticsearch")
+		w.Header().Set("Content-Type", "application/json")
+		requested = append(requested, r.URL.Path)
+
+		switch r.URL.Path {
+		case "/places-custom/_doc/7":
+			w.Write([]byte(`{"found": true, "_source": {"id": 7}}`))
+		case "/places-custom/_mget":
+			w.Write([]byte(`{"docs": [{"found": true, "_source": {"id": 7}}]}`))
+		default:
+			w.Write([]byte(`{"hits": {"hits": []}, "aggregations": {}}`))
+		}
+	}))
+	defer server.Close()
+
+	client, err := elasticsearch.NewClient(elasticsearch.Config{Addresses: []string{server.URL}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	paginator := &ElasticPaginator{Client: client, Index: "places-custom"}
+
+	tests := []struct {
+		name string
+		call func() error
+		path string
+	}{
+		{
+			name: "places",
+			call: func() error { _, _, err := paginator.GetPlaces(10, 0); return err },
+			path: "/places-custom/_search",
+		},
+		{
+			name: "place",
+			call: func() error { _, err := paginator.GetPlace(7); return err },
+			path: "/places-custom/_doc/7",
+		},
+		{
+			name: "canonical place",
+			call: func() error { _, err := paginator.GetCanonicalPlace(7); return err },
+			path: "/places-custom/_search",
+		},
+		{
+			name: "places by ids",
+			call: func() error { _, err := paginator.GetPlacesByIDs([]uint64{7}); return err },
+			path: "/places-custom/_mget",
+		},
+		{
+			name: "facets",
+			call: func() error { _, err := paginator.GetFacets(Filter{}, nil); return err },
+			path: "/places-custom/_search",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			requested = nil
+			if err := test.call(); err != nil {
+				t.Fatal(err)
+			}
+			if len(requested) != 1 || requested[0] != test.path {
+				t.Errorf("requested %q, want %q", requested, test.path)
+			}
+		})
+	}
+}